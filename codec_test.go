@@ -0,0 +1,1499 @@
+package vpacket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+	"github.com/vuuvv/vpacket/utils"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCodec 创建测试用的 Codec
+func newTestCodec(t *testing.T, scheme string) *Codec {
+	t.Helper()
+	Setup()
+	codec, err := NewCodecFromBytes([]byte(scheme))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return codec
+}
+
+// collect 扫描数据流直到结束, 并等待所有结果处理完
+// 结果处理在协程中执行, 顺序不保证; 每个结果在处理前都已计入统计, 按统计的数量等待
+func collect(t *testing.T, codec *Codec, stream io.Reader) []*ScanResult {
+	t.Helper()
+	ch := make(chan *ScanResult)
+	err := codec.Stream(stream).Scan(func(result *ScanResult) error {
+		ch <- result
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	stats := codec.Stats()
+	total := int(stats.Frames + stats.Errors + stats.Abandoned)
+	results := make([]*ScanResult, 0, total)
+	deadline := time.After(2 * time.Second)
+	for len(results) < total {
+		select {
+		case result := <-ch:
+			results = append(results, result)
+		case <-deadline:
+			t.Fatalf("expect %d results, got %d", total, len(results))
+		}
+	}
+	return results
+}
+
+// scanAll 扫描数据流并收集所有结果
+func scanAll(t *testing.T, scheme string, stream io.Reader) []*ScanResult {
+	t.Helper()
+	return collect(t, newTestCodec(t, scheme), stream)
+}
+
+// scanChunks 分多次写入数据流, io.Pipe 的每次读取最多返回一次写入的数据
+func scanChunks(t *testing.T, scheme string, chunks ...[]byte) []*ScanResult {
+	t.Helper()
+	reader, writer := io.Pipe()
+	go func() {
+		for _, chunk := range chunks {
+			_, _ = writer.Write(chunk)
+		}
+		_ = writer.Close()
+	}()
+	return scanAll(t, scheme, reader)
+}
+
+func countProtocol(results []*ScanResult, name string) int {
+	count := 0
+	for _, r := range results {
+		if r.Protocol != nil && r.Protocol.Name == name && r.ScanError == nil && !r.Abaddon {
+			count++
+		}
+	}
+	return count
+}
+
+func decodeHex(s string) []byte {
+	bs, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+// fakeClock 测试用的时钟, 只在 Advance 时前进
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (this *fakeClock) Now() time.Time {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.now
+}
+
+func (this *fakeClock) Advance(d time.Duration) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.now = this.now.Add(d)
+}
+
+// liveScan 在协程中扫描保持打开的数据流, 使用 fakeClock 计时, 用于测试数据流静默时的超时
+type liveScan struct {
+	clock   *fakeClock
+	writer  *io.PipeWriter
+	results chan *ScanResult
+	done    chan error
+}
+
+func startScan(t *testing.T, scheme string) *liveScan {
+	t.Helper()
+	codec := newTestCodec(t, scheme)
+	scan := &liveScan{clock: newFakeClock(), results: make(chan *ScanResult, 16), done: make(chan error, 1)}
+	codec.Clock(scan.clock.Now)
+
+	reader, writer := io.Pipe()
+	scan.writer = writer
+	go func() {
+		scan.done <- codec.Stream(reader).Scan(func(result *ScanResult) error {
+			scan.results <- result
+			return nil
+		})
+	}()
+	return scan
+}
+
+func (this *liveScan) Write(data []byte) {
+	_, _ = this.writer.Write(data)
+}
+
+// Next 等待下一个结果, step 大于 0 时等待期间不断推进时钟, 使超时在没有新数据时也能触发
+func (this *liveScan) Next(t *testing.T, step time.Duration) *ScanResult {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-this.results:
+			return result
+		case <-ticker.C:
+			if step > 0 {
+				this.clock.Advance(step)
+			}
+		case <-deadline:
+			t.Fatal("no result within 2s")
+			return nil
+		}
+	}
+}
+
+// Close 关闭数据流并等待扫描结束
+func (this *liveScan) Close(t *testing.T) {
+	t.Helper()
+	_ = this.writer.Close()
+	if err := <-this.done; err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+// markerProtocol 以 7273 开头, 第 3 个字节为长度的二进制协议, options 为协议的其它属性, fields 为 len 之后的字段
+func markerProtocol(name string, options string, fields string) string {
+	return fmt.Sprintf(`
+  - name: "%s"
+    type: "binary"%s
+    framing_rule:
+      header_marker: "7273"
+      length_offset: 2
+      length_size: 1
+    fields:
+      - name: "magic"
+        size: 2
+      - name: "len"
+        type: "uint"
+        size: 1%s`, name, options, fields)
+}
+
+// bodyField 长度为 len 的 body 字段, 用于 markerProtocol
+const bodyField = `
+      - name: "body"
+        size_expr: "int(fields.len)"`
+
+// payloadProtocol 只用于 Decode/Encode 测试的协议, 分包规则不参与测试, 编码时也不添加分隔符
+func payloadProtocol(name string, fields string) string {
+	return fmt.Sprintf(`
+  - name: "%s"
+    type: "text"
+    framing_rule:
+      end_delimiter: "0d0a"
+      contain_delimiter: true
+      max_len: 256
+    fields:%s`, name, fields)
+}
+
+// payloadScheme 由 data_structures 的内容和多个协议组成的 scheme
+func payloadScheme(structures string, protocols ...string) string {
+	scheme := ""
+	if structures != "" {
+		scheme = "data_structures:" + structures + "\n"
+	}
+	return scheme + "protocols:" + strings.Join(protocols, "") + "\n"
+}
+
+// mustScheme 解析并初始化 scheme
+func mustScheme(t *testing.T, content string) *Scheme {
+	t.Helper()
+	Setup()
+	scheme, err := NewScheme([]byte(content))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err = scheme.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return scheme
+}
+
+// encodeFields 使用协议编码字段
+func encodeFields(p *Protocol, fields map[string]any) ([]byte, error) {
+	ctx := NewContext(nil)
+	ctx.Flow = core.FlowEncode
+	ctx.Fields = fields
+	return p.Encode(ctx)
+}
+
+// codecCase 解码 frame 应该得到 expect, 编码 input 应该得到 frame
+type codecCase struct {
+	name   string
+	frame  string         // hex
+	expect map[string]any // 为空时不比较解码结果
+	input  map[string]any // 为空时编码解码的结果
+}
+
+func runCodecCases(t *testing.T, p *Protocol, cases ...codecCase) {
+	t.Helper()
+	for _, c := range cases {
+		frame := decodeHex(c.frame)
+		fields, err := p.Decode(frame)
+		if err != nil {
+			t.Fatalf("%s: decode %s: %+v", c.name, c.frame, err)
+		}
+		if c.expect != nil && !reflect.DeepEqual(fields, c.expect) {
+			t.Fatalf("%s: expect %v, got %v", c.name, c.expect, fields)
+		}
+
+		input := c.input
+		if input == nil {
+			input = fields.(map[string]any)
+		}
+		bs, err := encodeFields(p, input)
+		if err != nil {
+			t.Fatalf("%s: encode %v: %+v", c.name, input, err)
+		}
+		if !bytes.Equal(bs, frame) {
+			t.Fatalf("%s: expect %X, got %X", c.name, frame, bs)
+		}
+	}
+}
+
+var markerlessScheme = "protocols:" + markerProtocol("Marker", "", bodyField) + `
+  - name: "Sensor"
+    type: "text"
+    framing_rule:
+      length: 4
+      contain_delimiter: true
+    fields:
+      - name: "address"
+        type: "uint"
+        size: 1
+        check: "fields.address == 1"
+      - name: "value"
+        type: "uint"
+        size: 2
+      - name: "sum"
+        type: "uint"
+        size: 1
+        check: "int(fields.sum) == int(fields.address + fields.value) % 256"
+`
+
+func TestMarkerlessProtocol(t *testing.T) {
+	stream := decodeHex("01 0010 11  ff  7273 01 aa  01 0002 03")
+	results := scanAll(t, markerlessScheme, bytes.NewReader(stream))
+
+	if n := countProtocol(results, "Sensor"); n != 2 {
+		t.Fatalf("expect 2 sensor frames, got %d", n)
+	}
+	if n := countProtocol(results, "Marker"); n != 1 {
+		t.Fatalf("expect 1 marker frame, got %d", n)
+	}
+}
+
+const markerlessLengthScheme = `
+protocols:
+  - name: "Marker"
+    type: "binary"
+    framing_rule:
+      header_marker: "7273"
+      length_offset: 2
+      length_size: 1
+    fields:
+      - name: "body"
+        size: -1
+  - name: "Var"
+    type: "binary"
+    framing_rule:
+      length_offset: 0
+      length_size: 1
+    fields:
+      - name: "body"
+        size: -1
+`
+
+func TestMarkerlessWaitResync(t *testing.T) {
+	codec := newTestCodec(t, markerlessLengthScheme)
+	scanner := utils.NewScanner(nil)
+	split := codec.Splitter(scanner)
+
+	// 没有后续报文时, 按读到的长度等待
+	if advance, _, err := split(decodeHex("05 0102"), false); advance != 0 || err != nil {
+		t.Fatalf("expect wait, got advance %d, err %v", advance, err)
+	}
+
+	// 05 是脏数据, 后面已经有完整的 Marker 报文, 不再等待
+	advance, token, err := split(decodeHex("05 7273 01 aa"), false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	res := scanner.Result().(*core.FramingRuleMatchResult)
+	if advance != 1 || !bytes.Equal(token, decodeHex("05")) || !res.Abandoned {
+		t.Fatalf("expect 1 abandoned byte, got advance %d, token %X", advance, token)
+	}
+	if res.ResyncProtocol == nil || res.ResyncProtocol.Name != "Marker" {
+		t.Fatalf("expect resync to Marker, got %v", res.ResyncProtocol)
+	}
+}
+
+const encodeWithScheme = `
+protocols:
+  - name: "Command"
+    type: "binary"
+    framing_rule:
+      header_marker: "7273"
+      length_offset: 2
+      length_size: 1
+    fields:
+      - name: "magic"
+        default: "7273"
+        size: 2
+      - name: "len"
+        type: "uint"
+        size: 1
+        default: 1
+      - name: "cmd"
+        type: "uint"
+        size: 1
+  - name: "Heartbeat"
+    type: "text"
+    framing_rule:
+      start_delimiter: "s'['"
+      end_delimiter: "s']'"
+      max_len: 64
+    fields:
+      - name: "sn"
+        type: "string"
+        size: -1
+`
+
+func TestEncodeWith(t *testing.T) {
+	codec := newTestCodec(t, encodeWithScheme)
+
+	cases := []struct {
+		name     string
+		protocol string
+		input    map[string]any
+		expect   []byte
+	}{
+		{name: "first protocol by default", input: map[string]any{"cmd": 5}, expect: decodeHex("7273 01 05")},
+		{name: "protocol key", input: map[string]any{"protocol": "Heartbeat", "sn": "abc"}, expect: []byte("[abc]")},
+		{name: "explicit name", protocol: "Heartbeat", input: map[string]any{"protocol": "Command", "sn": "abc"}, expect: []byte("[abc]")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bs, err := codec.EncodeWith(c.protocol, c.input)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if !bytes.Equal(bs, c.expect) {
+				t.Fatalf("expect %X, got %X", c.expect, bs)
+			}
+		})
+	}
+
+	if _, err := codec.EncodeWith("Unknown", map[string]any{}); err == nil {
+		t.Fatal("expect error for unknown protocol")
+	}
+}
+
+var frameTimeoutScheme = "protocols:" + markerProtocol("Command", "\n    frame_timeout: 50ms", bodyField)
+
+func TestFrameTimeout(t *testing.T) {
+	scan := startScan(t, frameTimeoutScheme)
+	defer scan.Close(t)
+
+	// 半个报文后静默, 连接保持打开, 超时的数据也要被报告
+	scan.Write(decodeHex("7273 05 0102"))
+	r := scan.Next(t, 100*time.Millisecond)
+	if !r.Abaddon || r.ScanError == nil || !bytes.Equal(r.Packet, decodeHex("7273 05 0102")) {
+		t.Fatalf("expect stale bytes to be abandoned, got %+v", r)
+	}
+
+	// 超时后的完整报文正常解码
+	scan.Write(decodeHex("7273 02 aabb"))
+	if r = scan.Next(t, 0); r.Protocol == nil || r.Protocol.Name != "Command" || r.ScanError != nil || r.Abaddon {
+		t.Fatalf("expect command frame, got %+v", r)
+	}
+}
+
+func TestCoalesceAbandonedBytes(t *testing.T) {
+	codec := newTestCodec(t, markerlessScheme)
+	noise := bytes.Repeat([]byte{0xff}, 4096)
+	stream := append(append([]byte{}, noise...), decodeHex("7273 01 aa  ee ee")...)
+
+	results := collect(t, codec, bytes.NewReader(stream))
+	var junk []*ScanResult
+	for _, r := range results {
+		if r.Abaddon {
+			junk = append(junk, r)
+		}
+	}
+	if len(junk) != 2 {
+		t.Fatalf("expect 2 abandoned results, got %d", len(junk))
+	}
+	for _, r := range junk {
+		switch r.AbandonedBytes {
+		case len(noise):
+			if r.ResyncProtocol == nil || r.ResyncProtocol.Name != "Marker" {
+				t.Fatalf("expect resync to Marker, got %v", r.ResyncProtocol)
+			}
+		case 2:
+			if r.ResyncProtocol != nil {
+				t.Fatalf("expect no resync protocol for trailing junk, got %s", r.ResyncProtocol.Name)
+			}
+		default:
+			t.Fatalf("unexpected abandoned bytes %d", r.AbandonedBytes)
+		}
+		if r.Start == nil || r.End == nil || r.End.Before(*r.Start) {
+			t.Fatalf("expect start and end time, got %v - %v", r.Start, r.End)
+		}
+	}
+
+	stats := codec.Stats()
+	expect := CodecStats{Frames: 1, Abandoned: 2, AbandonedBytes: int64(len(noise) + 2), Resyncs: 1}
+	if stats != expect {
+		t.Fatalf("expect stats %+v, got %+v", expect, stats)
+	}
+}
+
+const overlappingMarkerScheme = `
+protocols:
+  - name: "Short"
+    type: "binary"
+    framing_rule:
+      header_marker: "68"
+      length_offset: 1
+      length_size: 1
+    fields:
+      - name: "body"
+        size: -1
+  - name: "Long"
+    type: "binary"
+    framing_rule:
+      header_marker: "6816"
+      length_offset: 2
+      length_size: 1
+    fields:
+      - name: "body"
+        size: -1
+`
+
+func TestOverlappingMarkers(t *testing.T) {
+	// 6816 比 68 长, 优先匹配
+	results := scanAll(t, overlappingMarkerScheme, bytes.NewReader(decodeHex("6816 01 aa  68 02 aabb")))
+	if n := countProtocol(results, "Long"); n != 1 {
+		t.Fatalf("expect 1 long frame, got %d", n)
+	}
+	if n := countProtocol(results, "Short"); n != 1 {
+		t.Fatalf("expect 1 short frame, got %d", n)
+	}
+}
+
+func TestPartialMarkerAcrossReads(t *testing.T) {
+	// 报文头标记被拆分到两次读取中
+	results := scanChunks(t, encodeWithScheme, decodeHex("ffff 72"), decodeHex("73 01 05"))
+	if n := countProtocol(results, "Command"); n != 1 {
+		t.Fatalf("expect 1 command frame, got %d", n)
+	}
+	for _, r := range results {
+		if r.Abaddon && r.AbandonedBytes != 2 {
+			t.Fatalf("expect 2 abandoned bytes, got %X", r.Packet)
+		}
+	}
+}
+
+// directionFields 只有 direction 等于参数时才能解码成功的字段
+const directionFields = `
+      - name: "direction"
+        type: "uint"
+        size: 1
+        check: "fields.direction == %d"
+      - name: "body"
+        size: -1`
+
+// sharedMarkerScheme 两个协议使用相同的报文头标记, 参数为 Downlink 的 priority
+func sharedMarkerScheme(priority int) string {
+	return "trial_decode: true\nprotocols:" +
+		markerProtocol("Uplink", "", fmt.Sprintf(directionFields, 1)) +
+		markerProtocol("Downlink", fmt.Sprintf("\n    priority: %d", priority), fmt.Sprintf(directionFields, 2))
+}
+
+func TestTrialDecodeSharedMarker(t *testing.T) {
+	stream := decodeHex("7273 02 02aa  7273 02 01bb  7273 02 03cc")
+	for _, c := range []struct {
+		priority int
+		rejected map[string][]string
+	}{
+		{0, map[string][]string{"Uplink": nil, "Downlink": {"Uplink"}, "": {"Uplink", "Downlink"}}},
+		{1, map[string][]string{"Uplink": {"Downlink"}, "Downlink": nil, "": {"Downlink", "Uplink"}}},
+	} {
+		results := scanAll(t, sharedMarkerScheme(c.priority), bytes.NewReader(stream))
+		if n := countProtocol(results, "Uplink"); n != 1 {
+			t.Fatalf("expect 1 uplink frame, got %d", n)
+		}
+		if n := countProtocol(results, "Downlink"); n != 1 {
+			t.Fatalf("expect 1 downlink frame, got %d", n)
+		}
+		for _, r := range results {
+			name := ""
+			if r.ScanError == nil {
+				name = r.Protocol.Name
+			}
+			var rejected []string
+			for _, rp := range r.Rejected {
+				rejected = append(rejected, rp.Protocol.Name)
+			}
+			if !reflect.DeepEqual(rejected, c.rejected[name]) {
+				t.Fatalf("priority %d, %X: expect rejected %v, got %v", c.priority, r.Packet, c.rejected[name], rejected)
+			}
+		}
+	}
+}
+
+var reassemblyScheme = "protocols:" + markerProtocol("Report", `
+    reassembly:
+      condition: "fields.total > 1"
+      message_id: "fields.msgId"
+      index: "fields.index"
+      total: "fields.total"
+      payload: "body"
+      index_base: 1
+      max_size: 16
+      ref: "Readings"`, `
+      - name: "msgId"
+        type: "uint"
+        size: 2
+      - name: "index"
+        type: "uint"
+        size: 1
+      - name: "total"
+        type: "uint"
+        size: 1
+      - name: "body"
+        size_expr: "int(fields.len) - 4"`) + `
+data_structures:
+  Readings:
+    fields:
+      - name: "temperature"
+        type: "uint"
+        size: 2
+      - name: "humidity"
+        type: "uint"
+        size: 2
+`
+
+func TestReassembly(t *testing.T) {
+	stream := decodeHex(
+		"7273 06 0007 02 02 0033" + // 消息 7 的第 2 包
+			"7273 05 0001 01 01 ff" + // 不分包
+			"7273 05 0009 01 02 aa" + // 消息 9 只收到第 1 包
+			"7273 06 0007 01 02 0102", // 消息 7 的第 1 包
+	)
+	results := scanAll(t, reassemblyScheme, bytes.NewReader(stream))
+	if len(results) != 3 {
+		t.Fatalf("expect 3 results, got %d", len(results))
+	}
+
+	var message, single, incomplete *ScanResult
+	for _, r := range results {
+		switch {
+		case r.ScanError != nil:
+			incomplete = r
+		case r.Fragments > 0:
+			message = r
+		default:
+			single = r
+		}
+	}
+	if message == nil || single == nil || incomplete == nil {
+		t.Fatalf("expect message, single frame and incomplete message")
+	}
+
+	fields := message.Data.(map[string]any)
+	if message.Fragments != 2 || fields["temperature"] != uint64(0x0102) || fields["humidity"] != uint64(0x33) {
+		t.Fatalf("unexpected message: %d fragments, %v", message.Fragments, fields)
+	}
+	if !bytes.Equal(message.Packet, decodeHex("0102 0033")) {
+		t.Fatalf("expect joined payload 01020033, got %X", message.Packet)
+	}
+	if single.Data.(map[string]any)["body"] != "FF" {
+		t.Fatalf("unexpected single frame: %v", single.Data)
+	}
+	if incomplete.Fragments != 1 || !bytes.Equal(incomplete.Packet, decodeHex("aa")) {
+		t.Fatalf("unexpected incomplete message: %d fragments, %X", incomplete.Fragments, incomplete.Packet)
+	}
+}
+
+func TestReassemblyMaxSize(t *testing.T) {
+	stream := decodeHex(
+		"7273 0d 0007 01 02 010203040506070809" +
+			"7273 0d 0007 02 02 010203040506070809" + // 超过 max_size
+			"7273 06 0007 01 02 0102",
+	)
+	results := scanAll(t, reassemblyScheme, bytes.NewReader(stream))
+	if len(results) != 2 {
+		t.Fatalf("expect 2 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.ScanError == nil {
+			t.Fatalf("expect error result, got %v", r.Data)
+		}
+	}
+}
+
+func TestReassemblyMaxMessages(t *testing.T) {
+	scheme := strings.Replace(reassemblyScheme, "max_size: 16", "max_size: 16\n      max_messages: 2", 1)
+	stream := decodeHex(
+		"7273 05 0001 01 02 aa" +
+			"7273 05 0002 01 02 bb" +
+			"7273 05 0003 01 02 cc" + // 超过 max_messages, 移除消息 1
+			"7273 05 0001 02 02 dd", // 消息 1 已被移除, 重新缓存并移除消息 2
+	)
+	results := scanAll(t, scheme, bytes.NewReader(stream))
+	if len(results) != 4 {
+		t.Fatalf("expect 4 results, got %d", len(results))
+	}
+	evicted := 0
+	for _, r := range results {
+		if r.ScanError == nil {
+			t.Fatalf("expect error result, got %v", r.Data)
+		}
+		if strings.Contains(r.ScanError.Error(), "max_messages") {
+			evicted++
+		}
+	}
+	if evicted != 2 {
+		t.Fatalf("expect 2 evicted messages, got %d", evicted)
+	}
+}
+
+func TestReassemblyTimeoutOnSilence(t *testing.T) {
+	scan := startScan(t, strings.Replace(reassemblyScheme, "max_size: 16", "max_size: 16\n      timeout: 1s", 1))
+	defer scan.Close(t)
+
+	// 连接保持打开且没有新的分包, 超时的消息也要被报告
+	scan.Write(decodeHex("7273 05 0009 01 02 aa"))
+	r := scan.Next(t, 500*time.Millisecond)
+	if r.ScanError == nil || !strings.Contains(r.ScanError.Error(), "timeout") || r.Fragments != 1 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+}
+
+const tunnelScheme = `
+protocols:
+  - name: "Gateway"
+    type: "binary"
+    framing_rule:
+      header_marker: "a55a"
+      length_offset: 2
+      length_size: 1
+      length_adjustment: 5
+    fields:
+      - name: "magic"
+        default: "a55a"
+        size: 2
+      - name: "len"
+        type: "uint"
+        size: 1
+      - name: "channel"
+        type: "uint"
+        size: 2
+      - name: "payload"
+        type: "tunnel"
+        protocol: "ModbusRtu"
+tunnel_protocols:
+  - name: "ModbusRtu"
+    type: "modbus_rtu"
+    framing_rule:
+      strip_crc: true
+    fields:
+      - name: "address"
+        type: "uint"
+        size: 1
+      - name: "function"
+        type: "uint"
+        size: 1
+      - name: "body"
+        size: -1
+`
+
+func TestTunnel(t *testing.T) {
+	gateway := mustScheme(t, tunnelScheme).GetProtocol("Gateway")
+
+	inner := map[string]any{"address": uint64(1), "function": uint64(3), "body": "0000000A"}
+	runCodecCases(t, gateway,
+		codecCase{
+			name:   "protocol",
+			frame:  "a55a 08 0002 0103 0000 000a c5cd",
+			expect: map[string]any{"magic": "A55A", "len": uint64(8), "channel": uint64(2), "payload": map[string]any{"protocol": "ModbusRtu", "data": inner}},
+			input:  map[string]any{"len": 8, "channel": 2, "payload": map[string]any{"protocol": "ModbusRtu", "data": map[string]any{"address": 1, "function": 3, "body": "0000000A"}}},
+		},
+		// 内层报文之后的数据放在 rest 中, 编码时原样写回
+		codecCase{
+			name:   "rest",
+			frame:  "a55a 0a 0002 0103 0000 000a c5cd beef",
+			expect: map[string]any{"magic": "A55A", "len": uint64(10), "channel": uint64(2), "payload": map[string]any{"protocol": "ModbusRtu", "data": inner, "rest": "BEEF"}},
+		},
+	)
+
+	if _, err := gateway.Decode(decodeHex("a55a 08 0002 0103 0000 000a c532")); err == nil {
+		t.Fatal("expect error for corrupted inner crc")
+	}
+
+	// tunnel_protocols 中的协议不参与数据流的分包, 单独出现的内层报文被丢弃
+	results := scanAll(t, tunnelScheme, bytes.NewReader(decodeHex("0103 0000 000a c5cd a55a 08 0002 0103 0000 000a c5cd")))
+	if countProtocol(results, "Gateway") != 1 || countProtocol(results, "ModbusRtu") != 0 {
+		t.Fatalf("expect only the gateway frame, got %v", results)
+	}
+}
+
+const signedScheme = `
+protocols:
+  - name: "Sensor"
+    type: "binary"
+    framing_rule:
+      header_marker: "5a"
+      length_offset: 1
+      length_size: 1
+    fields:
+      - name: "magic"
+        size: 1
+        default: "5a"
+      - name: "len"
+        type: "uint"
+        size: 1
+      - name: "temperature"
+        type: "int"
+        size: 2
+      - name: "offset"
+        type: "int"
+        size: 2
+        endian: "little"
+      - name: "flags"
+        bits: 4
+      - name: "humidity"
+        type: "int"
+        bits: 12
+`
+
+func TestSignedInt(t *testing.T) {
+	sensor := mustScheme(t, signedScheme).GetProtocol("Sensor")
+
+	runCodecCases(t, sensor,
+		codecCase{
+			name:   "signed",
+			frame:  "5a 08 fffe 0080 affe",
+			expect: map[string]any{"magic": "5A", "len": uint64(8), "temperature": int64(-2), "offset": int64(-32768), "flags": uint64(10), "humidity": int64(-2)},
+			input:  map[string]any{"len": 8, "temperature": -2, "offset": -32768, "flags": 10, "humidity": -2},
+		},
+		// 小数四舍五入, 不截断
+		codecCase{
+			name:  "round",
+			frame: "5a 08 fffe 0080 affe",
+			input: map[string]any{"len": 8.0, "temperature": -1.6, "offset": -32767.5, "flags": 9.7, "humidity": -2.4},
+		},
+	)
+
+	for _, input := range []map[string]any{{"temperature": 32768}, {"len": -1}, {"len": 256}, {"humidity": 2048}} {
+		if _, err := encodeFields(sensor, input); err == nil {
+			t.Fatalf("expect overflow error for %v", input)
+		}
+	}
+}
+
+var numericScheme = payloadScheme("", payloadProtocol("Meter", `
+      - name: "address"
+        size: 1
+      - name: "voltage"
+        type: "float"
+        size: 4
+        endian: "cdab"
+      - name: "current"
+        type: "float"
+        size: 2
+      - name: "energy"
+        type: "float"
+        size: 8
+        endian: "little"
+      - name: "temperature"
+        type: "uint"
+        size: 2
+        scale: 0.01`))
+
+func TestFloatAndScale(t *testing.T) {
+	meter := mustScheme(t, numericScheme).GetProtocol("Meter")
+
+	// 230.5 = 0x43668000, cdab 为 8000 4366; 1.5 的半精度为 3e00; 2315 = 0x090b
+	runCodecCases(t, meter, codecCase{
+		name:   "numeric",
+		frame:  "01 80004366 3e00 000000000000f83f 090b",
+		expect: map[string]any{"address": "01", "voltage": float32(230.5), "current": float32(1.5), "energy": float64(1.5), "temperature": 23.15},
+	})
+}
+
+var bcdScheme = payloadScheme("", payloadProtocol("Meter", `
+      - name: "magic"
+        size: 1
+      - name: "phone"
+        type: "bcd_string"
+        size: 6
+      - name: "energy"
+        type: "bcd"
+        size: 4
+        decimals: 2
+        endian: "little"
+      - name: "balance"
+        type: "packed"
+        size: 3
+        decimals: 1
+      - name: "count"
+        type: "bcd"
+        size: 1`))
+
+func TestBcdField(t *testing.T) {
+	meter := mustScheme(t, bcdScheme).GetProtocol("Meter")
+
+	runCodecCases(t, meter, codecCase{
+		name:   "bcd",
+		frame:  "68 013812345678 15230100 01234d 99",
+		expect: map[string]any{"magic": "68", "phone": "013812345678", "energy": 123.15, "balance": -123.4, "count": uint64(99)},
+	})
+
+	if _, err := meter.Decode(decodeHex("68 013812345678 151a0100 01234d 99")); err == nil || !strings.Contains(err.Error(), "energy") {
+		t.Fatalf("expect invalid nibble error of field energy, got %v", err)
+	}
+}
+
+var datetimeScheme = payloadScheme("", payloadProtocol("Clock", `
+      - name: "magic"
+        size: 1
+      - name: "unix"
+        type: "datetime"
+        format: "unix"
+        timezone: "UTC"
+      - name: "unixMs"
+        type: "datetime"
+        format: "unix_ms"
+        timezone: "UTC"
+      - name: "bcd"
+        type: "datetime"
+        format: "bcd"
+        timezone: "Asia/Shanghai"
+      - name: "binary"
+        type: "datetime"
+        format: "binary"
+        timezone: "Asia/Shanghai"
+        output: "time"
+      - name: "now"
+        type: "datetime"
+        size: 4
+        default: "now"`), payloadProtocol("BcdClock", `
+      - name: "magic"
+        size: 1
+      - name: "bcd"
+        type: "datetime"
+        format: "bcd"
+        timezone: "UTC"`))
+
+func TestDatetime(t *testing.T) {
+	clock := mustScheme(t, datetimeScheme).GetProtocol("Clock")
+
+	// 1764998575 = 2025-12-06T05:22:55Z, 北京时间 13:22:55
+	frame := decodeHex("7e 6933bdaf 0000019af21cf398 251206132255 190c060d1637 6933bdaf")
+	fields, err := clock.Decode(frame)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	data := fields.(map[string]any)
+	expectBinary := time.Date(2025, 12, 6, 13, 22, 55, 0, time.FixedZone("CST", 8*3600))
+	if data["unix"] != "2025-12-06T05:22:55Z" || data["unixMs"] != "2025-12-06T05:22:55Z" ||
+		data["bcd"] != "2025-12-06T13:22:55+08:00" || !data["binary"].(time.Time).Equal(expectBinary) {
+		t.Fatalf("unexpected datetime values %v", data)
+	}
+
+	bs, err := encodeFields(clock, map[string]any{
+		"magic":  "7e",
+		"unix":   1764998575,
+		"unixMs": "2025-12-06T05:22:55Z",
+		"bcd":    expectBinary,
+		"binary": "2025-12-06T05:22:55Z",
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(bs[:len(frame)-4], frame[:len(frame)-4]) {
+		t.Fatalf("expect %X, got %X", frame[:len(frame)-4], bs[:len(frame)-4])
+	}
+	if now := time.Now().Unix(); now-int64(binary.BigEndian.Uint32(bs[len(frame)-4:])) > 1 {
+		t.Fatalf("expect default now, got %X", bs[len(frame)-4:])
+	}
+
+	// bcd 的年份和编码一样从 2000 开始, 不会把 69-99 解释为 19xx
+	bcdClock := mustScheme(t, datetimeScheme).GetProtocol("BcdClock")
+	runCodecCases(t, bcdClock, codecCase{
+		name:   "year 2075",
+		frame:  "7e 750102030405",
+		expect: map[string]any{"magic": "7E", "bcd": "2075-01-02T03:04:05Z"},
+		input:  map[string]any{"magic": "7e", "bcd": "2075-01-02T03:04:05Z"},
+	})
+	for _, frame := range []string{"7e 751302030405", "7e 750100030405", "7e 750230030405", "7e 750102240405", "7e 750102036005"} {
+		if _, err := bcdClock.Decode(decodeHex(frame)); err == nil {
+			t.Fatalf("expect invalid bcd datetime error for %s", frame)
+		}
+	}
+}
+
+var bitfieldScheme = payloadScheme("", payloadProtocol("Status", `
+      - name: "magic"
+        size: 1
+      - name: "state"
+        type: "bitfield"
+        size: 2
+        fields:
+          - name: "mode"
+            bits: 3
+          - name: "alarm"
+            bits: 1
+          - bits: 4
+          - name: "level"
+            type: "int"
+            bits: 8
+      - name: "io"
+        type: "bitfield"
+        size: 1
+        bit_order: "lsb"
+        fields:
+          - name: "in1"
+            bits: 1
+          - name: "in2"
+            bits: 1
+          - name: "version"
+            bits: 2
+            default: 2`))
+
+func TestBitfield(t *testing.T) {
+	status := mustScheme(t, bitfieldScheme).GetProtocol("Status")
+
+	runCodecCases(t, status, codecCase{
+		name:  "bitfield",
+		frame: "55 b0fe 09",
+		expect: map[string]any{
+			"magic": "55",
+			"state": map[string]any{"mode": uint64(5), "alarm": uint64(1), "level": int64(-2)},
+			"io":    map[string]any{"in1": uint64(1), "in2": uint64(0), "version": uint64(2)},
+		},
+		input: map[string]any{
+			"magic": "55",
+			"state": map[string]any{"mode": 5, "alarm": 1, "level": -2},
+			"io":    map[string]any{"in1": 1},
+		},
+	})
+}
+
+var flagsScheme = payloadScheme(`
+  AlarmFlags:
+    flags:
+      - bit: 0
+        name: "door_open"
+      - bit: 3
+        name: "low_battery"
+      - bit: 9
+        name: "tamper"`, payloadProtocol("Alarm", `
+      - name: "magic"
+        size: 1
+      - name: "alarm"
+        type: "flags"
+        size: 2
+        ref: "AlarmFlags"
+      - name: "io"
+        type: "flags"
+        size: 1
+        flags:
+          - bit: 7
+            name: "relay"`))
+
+func TestFlags(t *testing.T) {
+	alarm := mustScheme(t, flagsScheme).GetProtocol("Alarm")
+
+	frame := "aa 0209 81"
+	runCodecCases(t, alarm,
+		codecCase{
+			name:  "decode",
+			frame: frame,
+			expect: map[string]any{
+				"magic": "AA",
+				"alarm": map[string]any{"value": uint64(0x0209), "flags": []any{"door_open", "low_battery", "tamper"}},
+				"io":    map[string]any{"value": uint64(0x81), "flags": []any{"relay"}},
+			},
+		},
+		codecCase{name: "unordered names", frame: frame, input: map[string]any{"magic": "aa", "alarm": []any{"tamper", "door_open", "low_battery"}, "io": 0x81}},
+		codecCase{name: "string names", frame: frame, input: map[string]any{"magic": "aa", "alarm": []string{"door_open", "low_battery", "tamper"}, "io": 0x81}},
+		codecCase{name: "value", frame: frame, input: map[string]any{"magic": "aa", "alarm": 0x0209, "io": 0x81}},
+	)
+
+	if _, err := encodeFields(alarm, map[string]any{"alarm": []any{"smoke"}}); err == nil {
+		t.Fatal("expect error for unknown flag")
+	}
+}
+
+var enumScheme = payloadScheme(`
+  ResultCode:
+    enum:
+      - value: 0
+        label: "ok"
+      - value: 0x10
+        label: "busy"
+  Command:
+    enum:
+      - value: "0a"
+        label: "read"
+      - value: "0B"
+        label: "write"`, payloadProtocol("Reply", `
+      - name: "magic"
+        size: 1
+      - name: "command"
+        size: 1
+        enum: "Command"
+        enum_unknown: "error"
+      - name: "code"
+        type: "uint"
+        size: 1
+        enum: "ResultCode"
+      - name: "state"
+        type: "uint"
+        size: 1
+        enum: "ResultCode"
+        enum_unknown: "null"`))
+
+func TestEnum(t *testing.T) {
+	reply := mustScheme(t, enumScheme).GetProtocol("Reply")
+
+	runCodecCases(t, reply,
+		codecCase{
+			name:  "labels",
+			frame: "cc 0b 07 10",
+			expect: map[string]any{
+				"magic":         "CC",
+				"command":       "0B",
+				"command_label": "write",
+				"code":          uint64(7),
+				"code_label":    "7",
+				"state":         uint64(0x10),
+				"state_label":   "busy",
+			},
+		},
+		codecCase{
+			name:   "null label",
+			frame:  "cc 0a 00 01",
+			expect: map[string]any{"magic": "CC", "command": "0A", "command_label": "read", "code": uint64(0), "code_label": "ok", "state": uint64(1), "state_label": nil},
+		},
+		codecCase{
+			name:  "encode label",
+			frame: "cc 0a 10 07",
+			input: map[string]any{"magic": "cc", "command": "read", "code": map[string]any{"label": "busy"}, "state": 7},
+		},
+	)
+
+	if _, err := reply.Decode(decodeHex("cc 0c 00 00")); err == nil {
+		t.Fatal("expect error for unknown command")
+	}
+	if _, err := encodeFields(reply, map[string]any{"command": "erase"}); err == nil {
+		t.Fatal("expect error for unknown command label")
+	}
+}
+
+var enumSwitchScheme = payloadScheme(`
+  Operation:
+    enum:
+      - value: 1
+        label: "read"
+      - value: 2
+        label: "write"`, payloadProtocol("Request", `
+      - name: "magic"
+        size: 1
+      - name: "op"
+        type: "uint"
+        size: 1
+        enum: "Operation"
+        check: "fields.op <= 2u"
+      - type: "switch"
+        name: "body"
+        field: "op"
+        cases:
+          - value: 1
+            fields:
+              - name: "value"
+                type: "uint"
+                size: 1
+          - value: 2
+            fields:
+              - name: "ack"
+                size: 1`))
+
+func TestEnumSwitchAndCheck(t *testing.T) {
+	request := mustScheme(t, enumSwitchScheme).GetProtocol("Request")
+
+	runCodecCases(t, request,
+		codecCase{name: "read", frame: "dd 01 2a", expect: map[string]any{"magic": "DD", "op": uint64(1), "op_label": "read", "value": uint64(42)}},
+		codecCase{name: "write", frame: "dd 02 ff", expect: map[string]any{"magic": "DD", "op": uint64(2), "op_label": "write", "ack": "FF"}},
+	)
+
+	// check 使用原始值
+	if _, err := request.Decode(decodeHex("dd 03 00")); err == nil || !strings.Contains(err.Error(), "check failed") {
+		t.Fatalf("expect check failed, got %v", err)
+	}
+}
+
+var tlvScheme = payloadScheme(`
+  Temperature:
+    fields:
+      - name: "value"
+        type: "int"
+        size: 2`, payloadProtocol("Record", `
+      - name: "magic"
+        size: 1
+      - name: "items"
+        type: "tlv"
+        tag_size: 1
+        length_size: 1
+        cases:
+          - value: 1
+            name: "temp"
+            ref: "Temperature"
+          - value: 3
+            name: "nested"
+            fields:
+              - name: "attrs"
+                type: "tlv"
+                cases:
+                  - value: 1
+                    name: "mode"
+                    fields:
+                      - name: "value"
+                        type: "uint"
+                        size: 1`))
+
+func TestTlv(t *testing.T) {
+	record := mustScheme(t, tlvScheme).GetProtocol("Record")
+
+	frame := "dd 0102ff9c 0303010107 0902abcd"
+	runCodecCases(t, record,
+		codecCase{
+			name:  "decode",
+			frame: frame,
+			expect: map[string]any{"magic": "DD", "items": []any{
+				map[string]any{"tag": uint64(1), "name": "temp", "value": map[string]any{"value": int64(-100)}},
+				map[string]any{"tag": uint64(3), "name": "nested", "value": map[string]any{"attrs": []any{
+					map[string]any{"tag": uint64(1), "name": "mode", "value": map[string]any{"value": uint64(7)}},
+				}}},
+				map[string]any{"tag": uint64(9), "name": "", "value": "ABCD"},
+			}},
+		},
+		codecCase{
+			name:  "list",
+			frame: frame,
+			input: map[string]any{"magic": "dd", "items": []any{
+				map[string]any{"name": "temp", "value": map[string]any{"value": -100}},
+				map[string]any{"tag": 3, "value": map[string]any{"attrs": map[string]any{"mode": map[string]any{"value": 7}}}},
+				map[string]any{"tag": 9, "value": "abcd"},
+			}},
+		},
+		codecCase{
+			name:  "map",
+			frame: frame,
+			input: map[string]any{"magic": "dd", "items": map[string]any{
+				"9":      "abcd",
+				"nested": map[string]any{"attrs": []any{map[string]any{"tag": 1, "value": map[string]any{"value": 7}}}},
+				"temp":   map[string]any{"value": -100},
+			}},
+		},
+	)
+
+	if _, err := encodeFields(record, map[string]any{"magic": "dd", "items": map[string]any{"humidity": "01"}}); err == nil {
+		t.Fatal("expect error for unknown tag name")
+	}
+	if _, err := record.Decode(decodeHex("dd 0105ff9c 0303010107 09")); err == nil {
+		t.Fatal("expect error for truncated item")
+	}
+}
+
+var repeatScheme = payloadScheme("",
+	payloadProtocol("Records", `
+      - name: "magic"
+        size: 1
+      - name: "count"
+        type: "uint"
+        size: 1
+      - name: "points"
+        type: "array"
+        byte_size_expr: "int(fields.count) * 2"
+        fields:
+          - name: "points.id"
+            type: "uint"
+            size: 1
+          - name: "points.value"
+            type: "uint"
+            size: 1
+      - name: "names"
+        type: "array"
+        repeat: "until"
+        terminator: "00"
+        fields:
+          - name: "names"
+            type: "uint"
+            size: 1
+      - name: "tail"
+        type: "array"
+        repeat: "until"
+        until: "val.last == 1u"
+        fields:
+          - name: "tail.last"
+            type: "uint"
+            size: 1
+          - name: "tail.v"
+            type: "uint"
+            size: 1
+      - name: "rest"
+        type: "array"
+        repeat: "eos"
+        fields:
+          - name: "rest"
+            type: "uint"
+            size: 1`),
+	payloadProtocol("Limited", `
+      - name: "magic"
+        size: 1
+      - name: "rest"
+        type: "array"
+        repeat: "eos"
+        max_items: 1
+        fields:
+          - name: "rest"
+            type: "uint"
+            size: 1`),
+)
+
+func TestArrayRepeat(t *testing.T) {
+	scheme := mustScheme(t, repeatScheme)
+
+	runCodecCases(t, scheme.GetProtocol("Records"), codecCase{
+		name:  "repeat",
+		frame: "ee 02 01020304 0a0b00 00050106 0708",
+		expect: map[string]any{
+			"magic": "EE",
+			"count": uint64(2),
+			"points": []any{
+				map[string]any{"id": uint64(1), "value": uint64(2)},
+				map[string]any{"id": uint64(3), "value": uint64(4)},
+			},
+			"names": []any{uint64(0x0a), uint64(0x0b)},
+			"tail": []any{
+				map[string]any{"last": uint64(0), "v": uint64(5)},
+				map[string]any{"last": uint64(1), "v": uint64(6)},
+			},
+			"rest": []any{uint64(7), uint64(8)},
+		},
+	})
+
+	if _, err := scheme.GetProtocol("Limited").Decode(decodeHex("ef 0708")); err == nil {
+		t.Fatal("expect error for exceeding max_items")
+	}
+
+	// repeat until 编码的元素应该能被解码回来: until 只在最后一个元素成立, 元素不能以 terminator 开头
+	tail := func(last ...uint64) []any {
+		var items []any
+		for i, l := range last {
+			items = append(items, map[string]any{"last": l, "v": uint64(i)})
+		}
+		return items
+	}
+	for _, c := range []struct {
+		names  []any
+		tail   []any
+		expect string
+	}{
+		{[]any{1, 2}, tail(1, 1), "until is true at item 0"},
+		{[]any{1, 2}, tail(0, 0), "until should be true at the last item"},
+		{[]any{1, 2}, nil, "at least one item"},
+		{[]any{1, 0}, tail(1), "starts with terminator"},
+	} {
+		_, err := encodeFields(scheme.GetProtocol("Records"), map[string]any{
+			"magic": "ee", "count": 0, "names": c.names, "tail": c.tail,
+		})
+		if err == nil || !strings.Contains(err.Error(), c.expect) {
+			t.Fatalf("expect error %q, got %v", c.expect, err)
+		}
+	}
+}
+
+var scopedArrayScheme = payloadScheme("", payloadProtocol("Groups", `
+      - name: "magic"
+        size: 1
+      - name: "count"
+        type: "uint"
+        size: 1
+        flow: "decode"
+      - name: "count"
+        type: "calc"
+        size: 1
+        flow: "encode"
+        formula: "size(fields.groups)"
+      - name: "groups"
+        type: "array"
+        size_expr: "int(fields.count)"
+        fields:
+          - name: "id"
+            type: "uint"
+            size: 1
+          - name: "n"
+            type: "uint"
+            size: 1
+            flow: "decode"
+          - name: "n"
+            type: "calc"
+            size: 1
+            flow: "encode"
+            formula: "size(item.values)"
+          - name: "values"
+            type: "array"
+            size_expr: "int(item.n)"
+            fields:
+              - name: "v"
+                type: "uint"
+                size: 1
+              - name: "group"
+                type: "calc"
+                flow: "decode"
+                formula: "parent.id"
+              - name: "pos"
+                type: "calc"
+                flow: "decode"
+                formula: "index"`))
+
+func TestArrayScope(t *testing.T) {
+	groups := mustScheme(t, scopedArrayScheme).GetProtocol("Groups")
+
+	runCodecCases(t, groups, codecCase{
+		name:  "scope",
+		frame: "f0 02 01020a0b 02010c",
+		expect: map[string]any{"magic": "F0", "count": uint64(2), "groups": []any{
+			map[string]any{"id": uint64(1), "n": uint64(2), "values": []any{
+				map[string]any{"v": uint64(10), "group": uint64(1), "pos": int64(0)},
+				map[string]any{"v": uint64(11), "group": uint64(1), "pos": int64(1)},
+			}},
+			map[string]any{"id": uint64(2), "n": uint64(1), "values": []any{
+				map[string]any{"v": uint64(12), "group": uint64(2), "pos": int64(0)},
+			}},
+		}},
+		input: map[string]any{
+			"magic": "f0",
+			"groups": []any{
+				map[string]any{"id": 1, "values": []any{map[string]any{"v": 10}, map[string]any{"v": 11}}},
+				map[string]any{"id": 2, "values": []map[string]any{{"v": 12}}},
+			},
+		},
+	})
+
+	// 元素缺少的字段不能从外层或根字段的同名字段获取
+	for _, input := range []map[string]any{
+		{"magic": "f0", "id": 7, "groups": []any{map[string]any{"id": 1, "values": []any{}}, map[string]any{"values": []any{}}}},
+		{"magic": "f0", "v": 7, "groups": []any{map[string]any{"id": 1, "v": 9, "values": []any{map[string]any{}}}}},
+	} {
+		if _, err := encodeFields(groups, input); err == nil || !strings.Contains(errors.Cause(err).Error(), "missing") {
+			t.Fatalf("expect missing item field error for %v, got %v", input, err)
+		}
+	}
+}
+
+var structScheme = payloadScheme(`
+  Point:
+    fields:
+      - name: "x"
+        type: "uint"
+        size: 1
+      - name: "y"
+        type: "uint"
+        size: 1
+      - name: "sum"
+        type: "calc"
+        flow: "decode"
+        formula: "self.x + self.y"`, payloadProtocol("Shape", `
+      - name: "magic"
+        size: 1
+      - name: "from"
+        type: "struct"
+        namespace: true
+        ref: "Point"
+      - name: "to"
+        type: "struct"
+        namespace: true
+        ref: "Point"
+      - name: "meta"
+        type: "struct"
+        namespace: true
+        fields:
+          - name: "kind"
+            type: "uint"
+            size: 1
+          - name: "dx"
+            type: "calc"
+            flow: "decode"
+            formula: "parent.to.x - parent.from.x"`))
+
+// legacyStructScheme 没有 namespace 的结构体, 子字段使用完整的字段名
+var legacyStructScheme = payloadScheme(`
+  ResponseCode:
+    fields:
+      - name: "data.code"
+        type: "uint"
+        size: 1
+      - name: "data.success"
+        type: "calc"
+        flow: "decode"
+        formula: "fields.data.code == 0u"`, payloadProtocol("Reply", `
+      - name: "magic"
+        size: 1
+      - name: "data.response.code"
+        type: "struct"
+        ref: "ResponseCode"`))
+
+func TestLegacyStruct(t *testing.T) {
+	runCodecCases(t, mustScheme(t, legacyStructScheme).GetProtocol("Reply"), codecCase{
+		name:   "absolute names",
+		frame:  "fb 00",
+		expect: map[string]any{"magic": "FB", "data": map[string]any{"code": uint64(0), "success": true}},
+	})
+}
+
+func TestStructScope(t *testing.T) {
+	runCodecCases(t, mustScheme(t, structScheme).GetProtocol("Shape"), codecCase{
+		name:  "namespace",
+		frame: "fa 0102 0304 09",
+		expect: map[string]any{
+			"magic": "FA",
+			"from":  map[string]any{"x": uint64(1), "y": uint64(2), "sum": uint64(3)},
+			"to":    map[string]any{"x": uint64(3), "y": uint64(4), "sum": uint64(7)},
+			"meta":  map[string]any{"kind": uint64(9), "dx": uint64(2)},
+		},
+		input: map[string]any{
+			"magic": "fa",
+			"from":  map[string]any{"x": 1, "y": 2},
+			"to":    map[string]any{"x": 3, "y": 4},
+			"meta":  map[string]any{"kind": 9},
+		},
+	})
+}
@@ -8,19 +8,24 @@ import (
 	"gopkg.in/yaml.v3"
 	"io"
 	"os"
+	"slices"
 	"time"
 )
 
 type ScanResult struct {
-	Sn          string     `json:"sn"`                 // 直接连接的设备序列号
-	Abaddon     bool       `json:"abaddon,omitempty"`  // 是否为丢弃的包
-	Packet      []byte     `json:"packet,omitempty"`   // 为解析的原始包
-	Protocol    *Protocol  `json:"protocol,omitempty"` // 使用的协议
-	Data        any        `json:"data,omitempty"`     // 解析出来的数据
-	ScanError   error      `json:"scanError,omitempty"`
-	HandleError error      `json:"handleError,omitempty"`
-	Start       *time.Time `json:"start,omitempty"`
-	End         *time.Time `json:"end,omitempty"`
+	Sn             string              `json:"sn"`                       // 直接连接的设备序列号
+	Abaddon        bool                `json:"abaddon,omitempty"`        // 是否为丢弃的包
+	AbandonedBytes int                 `json:"abandonedBytes,omitempty"` // 丢弃的字节数, 连续的脏数据合并为一个结果
+	ResyncProtocol *Protocol           `json:"resyncProtocol,omitempty"` // 丢弃后重新同步到的协议
+	Packet         []byte              `json:"packet,omitempty"`         // 为解析的原始包
+	Protocol       *Protocol           `json:"protocol,omitempty"`       // 使用的协议
+	Data           any                 `json:"data,omitempty"`           // 解析出来的数据
+	ScanError      error               `json:"scanError,omitempty"`
+	Rejected       []*RejectedProtocol `json:"rejected,omitempty"`  // trial_decode 时试解码失败的协议
+	Fragments      int                 `json:"fragments,omitempty"` // 多帧重组的消息的分包数, Packet 为拼接后的消息
+	HandleError    error               `json:"handleError,omitempty"`
+	Start          *time.Time          `json:"start,omitempty"`
+	End            *time.Time          `json:"end,omitempty"` // 处理结束的时间, 丢弃的结果为重新同步的时间
 }
 
 func (this *ScanResult) Run(fn ScanResultHandler) {
@@ -31,22 +36,30 @@ func (this *ScanResult) Run(fn ScanResultHandler) {
 	if err != nil {
 		this.HandleError = err
 	}
-	if this.End != nil {
+	if this.End == nil {
 		now := time.Now()
 		this.End = &now
 	}
 }
 
+// RejectedProtocol 试解码失败的协议
+type RejectedProtocol struct {
+	Protocol *Protocol `json:"protocol"`
+	Error    error     `json:"error"`
+}
+
 type ScanResultHandler func(result *ScanResult) error
 
 type Codec struct {
 	scheme  *Scheme
 	stream  io.Reader
 	history *utils.LockFreeCircularBuffer
+	stats   codecStats
+	now     func() time.Time // 时钟, 用于超时的计算, 测试时可以替换
 }
 
 func NewCodec() *Codec {
-	scanner := &Codec{}
+	scanner := &Codec{now: time.Now}
 	scanner.history = utils.NewLockFreeCircularBuffer(10)
 	return scanner
 }
@@ -89,6 +102,12 @@ func (this *Codec) Stream(stream io.Reader) *Codec {
 	return this
 }
 
+// Clock 设置计算超时使用的时钟, 默认为 time.Now
+func (this *Codec) Clock(now func() time.Time) *Codec {
+	this.now = now
+	return this
+}
+
 func (this *Codec) AddHistory(history any) *Codec {
 	this.history.Add(&utils.WithTime{Time: time.Now(), Data: history})
 	return this
@@ -105,11 +124,36 @@ func (this *Codec) Histories() []*utils.WithTime {
 	return res
 }
 
+// EncodeProtocolKey 输入数据中用于指定编码协议的键
+const EncodeProtocolKey = "protocol"
+
 func (this *Codec) Encode(input map[string]any) ([]byte, error) {
+	return this.EncodeWith("", input)
+}
+
+// EncodeWith 使用指定的协议编码
+// 协议的选择顺序: protocolName, input 中的 protocol 键, 第一个协议
+func (this *Codec) EncodeWith(protocolName string, input map[string]any) ([]byte, error) {
 	if len(this.scheme.Protocols) < 1 {
 		return nil, errors.New("No Protocols configured")
 	}
+	if protocolName == "" {
+		if val, ok := input[EncodeProtocolKey]; ok {
+			name, ok := val.(string)
+			if !ok {
+				return nil, errors.Errorf("Encode: '%s' should be a string, actual %T", EncodeProtocolKey, val)
+			}
+			protocolName = name
+		}
+	}
+
 	protocol := this.scheme.Protocols[0]
+	if protocolName != "" {
+		protocol = this.scheme.GetProtocol(protocolName)
+		if protocol == nil {
+			return nil, errors.Errorf("Encode: protocol '%s' not found", protocolName)
+		}
+	}
 
 	ctx := NewContext(nil)
 	ctx.Fields = input
@@ -126,11 +170,43 @@ func (this *Codec) EncodeFromJson(input string) ([]byte, error) {
 	return this.Encode(data)
 }
 
+// idleInterval 数据流静默时检查超时的间隔, 为最短超时时间的 1/4, 没有配置超时时为 0
+func (this *Codec) idleInterval() time.Duration {
+	var interval time.Duration
+	for _, p := range this.scheme.Protocols {
+		timeouts := []time.Duration{p.FrameTimeout}
+		if p.Reassembly != nil {
+			timeouts = append(timeouts, p.Reassembly.Timeout)
+		}
+		for _, timeout := range timeouts {
+			if timeout > 0 && (interval == 0 || timeout < interval) {
+				interval = timeout
+			}
+		}
+	}
+	if interval == 0 {
+		return 0
+	}
+	return max(interval/4, time.Millisecond)
+}
+
 func (this *Codec) Scan(fn ScanResultHandler) error {
-	scanner := utils.NewScanner(this.stream)
+	stream := this.stream
+	if interval := this.idleInterval(); interval > 0 {
+		// 数据流静默时也能定时检查 frame_timeout 和分包超时
+		idle := utils.NewIdleReader(stream, interval)
+		defer func() { _ = idle.Close() }()
+		stream = idle
+	}
+	scanner := utils.NewScanner(stream)
 	scanner.Split(this.Splitter(scanner))
+	fragments := newReassembler(this.now)
 
 	for scanner.Scan() {
+		// 每次扫描(包括数据流静默时)都检查未收齐的分包是否超时
+		for _, expired := range fragments.Expire(this.now()) {
+			this.EmitResult(expired, fn)
+		}
 		scannerResult := scanner.Result()
 		if scannerResult == nil {
 			continue
@@ -141,11 +217,19 @@ func (this *Codec) Scan(fn ScanResultHandler) error {
 		}
 
 		result := &ScanResult{
-			Abaddon:   framingRuleResult.Abandoned,
-			Packet:    framingRuleResult.Token,
-			Protocol:  framingRuleResult.Protocol,
-			ScanError: framingRuleResult.Error,
-			Start:     &framingRuleResult.Time,
+			Abaddon:        framingRuleResult.Abandoned,
+			ResyncProtocol: framingRuleResult.ResyncProtocol,
+			Packet:         bytes.Clone(framingRuleResult.Token), // token 指向扫描缓冲区, 后续扫描会覆盖
+			Protocol:       framingRuleResult.Protocol,
+			ScanError:      framingRuleResult.Error,
+			Rejected:       framingRuleResult.Rejected,
+			Start:          &framingRuleResult.Time,
+		}
+		if result.Abaddon {
+			result.AbandonedBytes = len(result.Packet)
+		}
+		if !framingRuleResult.EndTime.IsZero() {
+			result.End = &framingRuleResult.EndTime
 		}
 
 		// 分包有错误
@@ -159,18 +243,38 @@ func (this *Codec) Scan(fn ScanResultHandler) error {
 			continue
 		}
 
-		data, err := result.Protocol.Decode(result.Packet)
-		result.Data = data
-		if err != nil {
-			result.ScanError = err
-			this.EmitResult(result, fn)
-			continue
+		if framingRuleResult.Decoded {
+			result.Data = framingRuleResult.Data
+		} else {
+			data, err := result.Protocol.Decode(result.Packet)
+			result.Data = data
+			if err != nil {
+				result.ScanError = err
+				this.EmitResult(result, fn)
+				continue
+			}
+		}
+
+		if result.Protocol.Reassembly != nil {
+			if fields, ok := result.Data.(map[string]any); ok {
+				messages, consumed := fragments.Add(result.Protocol, fields)
+				if consumed {
+					// 分包未收齐时不返回结果
+					for _, message := range messages {
+						this.EmitResult(message, fn)
+					}
+					continue
+				}
+			}
 		}
 		this.EmitResult(result, fn)
 	}
 
 	// 解码结束, 移除结果
 	scanner.SetResult(nil)
+	for _, incomplete := range fragments.Flush() {
+		this.EmitResult(incomplete, fn)
+	}
 
 	// 如果scanner.err是EOF错误,scanner.Err()返回的错误是空,代表不是错误,是正常结束
 	if err := scanner.Err(); err != nil {
@@ -180,47 +284,279 @@ func (this *Codec) Scan(fn ScanResultHandler) error {
 }
 
 func (this *Codec) EmitResult(result *ScanResult, fn ScanResultHandler) {
+	this.stats.add(result)
 	this.history.Add(result)
 	// 因为是指针,所有后面的修改会影响history中的数据
 	go result.Run(fn)
 }
 
+// Splitter 创建分包函数
+// 每个位置先尝试报文头标记匹配的协议, priority 大的优先, 然后标记长的优先, 最后按声明顺序, 都不匹配时再按 priority 和声明顺序尝试没有报文头标记的协议.
+// scheme 开启 trial_decode 时, 报文头标记匹配的协议分包后都需要试解码, 使用第一个解码成功的协议, 失败的协议记录在结果中.
+// 报文头标记使用 Aho-Corasick 自动机匹配, 没有 "没有报文头标记的协议" 时, 丢弃数据后直接跳到下一个可能的标记位置,
+// 数据末尾是某个标记的前缀时等待更多数据.
+// 没有报文头标记的协议在分包后需要试解码成功(CRC, check 等校验通过)才被确认, 否则继续尝试下一个协议,
+// 所有协议都不匹配时丢弃一个字节, 连续丢弃的字节合并为一个结果.
+// 没有报文头标记的协议等待更多数据时, 如果后面的数据中已经有报文头标记的协议能分出完整的报文, 则不再等待, 丢弃一个字节.
+// 协议配置了 frame_timeout 时, 不完整的报文从第一次等待开始计时, 超时后丢弃之前缓存的数据,
+// 数据流静默时 Scan 会定时调用分包函数检查超时, 不需要等到收到新数据.
 func (this *Codec) Splitter(scanner *utils.Scanner) utils.SplitFunc {
-	type Matcher struct {
-		Def    *Protocol
-		Marker []byte
-	}
-	var matchers []Matcher
+	var matchers []*Protocol
+	var markers [][]byte
+	var markerless []*Protocol
 
 	for _, p := range this.scheme.Protocols {
 		marker := p.ParsedFramingRule.GetHeaderMarker()
 
 		if len(marker) > 0 {
-			matchers = append(matchers, Matcher{Def: p, Marker: marker})
+			matchers = append(matchers, p)
+			markers = append(markers, marker)
+		} else {
+			markerless = append(markerless, p)
+		}
+	}
+	automaton := utils.NewAhoCorasick(markers)
+	// priority 大的协议优先尝试
+	byPriority := func(a, b *Protocol) int { return b.Priority - a.Priority }
+	slices.SortStableFunc(markerless, byPriority)
+	candidates := func(ids []int) []*Protocol {
+		ret := make([]*Protocol, 0, len(ids))
+		for _, id := range ids {
+			ret = append(ret, matchers[id])
 		}
+		slices.SortStableFunc(ret, byPriority)
+		return ret
 	}
 
-	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// 等待中的不完整报文, pendingStart 为第一次等待的时间, pendingSize 为最后一次等待时缓存的数据长度
+	var pending *FramingRuleMatchResult
+	var pendingStart time.Time
+	var pendingSize int
+	wait := func(p *Protocol, res *FramingRuleMatchResult, data []byte) {
+		if pending == nil && p != nil && p.FrameTimeout > 0 {
+			pending = res
+			pendingStart = this.now()
+		}
+		if pending != nil {
+			pendingSize = len(data)
+		}
+	}
+
+	// match 在数据开头匹配报文, 返回的结果: 丢弃(Abandoned), 报文(Advance > 0), 等待(Advance 为 0 且没有错误), 错误
+	// trial 尝试所有分包成功的协议, 返回第一个解码成功的结果, 都失败时返回第一个分包成功的协议和它的错误
+	trial := func(protocols []*Protocol, data []byte, atEOF bool) *FramingRuleMatchResult {
+		var rejected []*RejectedProtocol
+		var first, waiting *FramingRuleMatchResult
+		for _, p := range protocols {
+			res := p.ParsedFramingRule.Split(data)
+			if res == nil || res.Abandoned {
+				continue
+			}
+			res.Protocol = p
+			if res.Advance == 0 && res.Error == nil {
+				if waiting == nil && !atEOF {
+					waiting = res
+				}
+				continue
+			}
+			if res.Error == nil {
+				decoded, err := p.Decode(res.Token)
+				if err == nil {
+					res.Decoded = true
+					res.Data = decoded
+					res.Rejected = rejected
+					return res
+				}
+				res.Error = err
+			}
+			rejected = append(rejected, &RejectedProtocol{Protocol: p, Error: res.Error})
+			if first == nil && res.Advance > 0 {
+				first = res
+			}
+		}
+		if waiting != nil {
+			// 其他协议的报文还不完整, 可能解码成功
+			return waiting
+		}
+		if first != nil {
+			first.Rejected = rejected
+		}
+		return first
+	}
+
+	// markerAhead 数据后面的位置有报文头标记匹配的协议能分出完整的报文
+	markerAhead := func(data []byte) bool {
+		for i := 1; i < len(data); i++ {
+			next := automaton.Index(data[i:])
+			if next < 0 {
+				return false
+			}
+			i += next
+			ids, _ := automaton.MatchPrefix(data[i:])
+			for _, p := range candidates(ids) {
+				if res := p.ParsedFramingRule.Split(data[i:]); res != nil && res.Advance > 0 && res.Error == nil {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	match := func(data []byte, atEOF bool) *FramingRuleMatchResult {
+		ids, partial := automaton.MatchPrefix(data)
+		protocols := candidates(ids)
+		if this.scheme.TrialDecode && len(protocols) > 0 {
+			if res := trial(protocols, data, atEOF); res != nil {
+				return res
+			}
+			protocols = nil
+		}
+		for _, p := range protocols {
+			res := p.ParsedFramingRule.Split(data)
+
+			if res != nil && (res.Advance > 0 || res.Error != nil) {
+				res.Protocol = p
+				return res
+			}
+			if res != nil && !res.Abandoned && !atEOF {
+				// 报文不完整, 等待更多数据
+				res.Protocol = p
+				return res
+			}
+		}
+
+		var waiting *FramingRuleMatchResult
+		for _, p := range markerless {
+			res := p.ParsedFramingRule.Split(data)
+			if res == nil || res.Abandoned || res.Error != nil {
+				continue
+			}
+			if res.Advance == 0 {
+				// 多个协议等待时, 使用超时时间最长的协议, 0 表示一直等待
+				if waiting == nil || (waiting.Protocol.FrameTimeout > 0 && (p.FrameTimeout == 0 || p.FrameTimeout > waiting.Protocol.FrameTimeout)) {
+					res.Protocol = p
+					waiting = res
+				}
+				continue
+			}
+			// 没有报文头标记, 需要试解码确认
+			decoded, err := p.Decode(res.Token)
+			if err != nil {
+				continue
+			}
+			res.Protocol = p
+			res.Decoded = true
+			res.Data = decoded
+			return res
+		}
+		if waiting != nil && !atEOF && !markerAhead(data) {
+			// 后面有完整的报文时, 等待的长度可能是从脏数据中读到的, 不再等待
+			return waiting
+		}
+		if partial && !atEOF {
+			// 数据可能是更长的报文头标记的开始, 等待更多数据
+			return WaitFramingRuleMatchResult()
+		}
+
+		// 没有匹配到就丢弃第一个数据
+		return AbandonFramingRuleMatchResult(1, data)
+	}
+
+	// carry 后面跟着不完整报文的脏数据, 先消费但不返回, 等确认重新同步后和后续的脏数据合并返回
+	var carry *FramingRuleMatchResult
+	// abandon 合并暂存和本次的脏数据
+	abandon := func(junk *FramingRuleMatchResult, data []byte) *FramingRuleMatchResult {
+		if carry == nil {
+			junk.Token = bytes.Clone(data)
+			junk.Advance = len(data)
+			junk.Protocol = nil
+			return junk
+		}
+		carry.Token = append(carry.Token, data...)
+		carry.Advance += len(data)
+		return carry
+	}
+	emit := func(junk *FramingRuleMatchResult, advance int, resync *Protocol) (int, []byte, error) {
+		carry = nil
+		junk.ResyncProtocol = resync
+		junk.EndTime = this.now()
+		scanner.SetResult(junk)
+		return advance, junk.Token, nil
+	}
+
+	split := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if len(data) == 0 {
+			if atEOF && carry != nil {
+				return emit(carry, 0, nil)
+			}
 			return 0, nil, nil
 		}
-		var res *FramingRuleMatchResult
 
-		for _, m := range matchers {
-			if bytes.HasPrefix(data, m.Marker) {
-				res = m.Def.ParsedFramingRule.Split(data)
+		if pending != nil && this.now().Sub(pendingStart) > pending.Protocol.FrameTimeout {
+			if carry != nil {
+				return emit(carry, 0, nil)
+			}
+			p := pending.Protocol
+			res := AbandonFramingRuleMatchResult(min(pendingSize, len(data)), data)
+			res.Protocol = p
+			res.Error = errors.Errorf("Protocol '%s': incomplete frame timeout after %s", p.Name, p.FrameTimeout)
+			scanner.SetResult(res)
+			return res.Advance, res.Token, nil
+		}
+
+		// 连续的脏数据合并为一个丢弃结果, 直到重新匹配到报文为止
+		var junk *FramingRuleMatchResult
+		for junkSize := 0; junkSize < len(data); {
+			res := match(data[junkSize:], atEOF)
+			if res.Abandoned {
+				if junk == nil {
+					junk = res
+				}
+				junkSize += res.Advance
+				if len(markerless) == 0 && junkSize < len(data) {
+					// 跳到下一个可能的报文头标记
+					next := automaton.Index(data[junkSize:])
+					if next < 0 {
+						junkSize = len(data)
+					} else {
+						junkSize += next
+					}
+				}
+				continue
+			}
 
-				if res != nil && (res.Advance > 0 || res.Error != nil) {
-					res.Protocol = m.Def
-					scanner.SetResult(res)
-					return res.Advance, res.Token, res.Error
+			if res.Advance == 0 && res.Error == nil {
+				if junkSize > 0 {
+					// 还不能确认是否重新同步, 暂存脏数据
+					carry = abandon(junk, data[:junkSize])
+					return junkSize, nil, nil
 				}
+				wait(res.Protocol, res, data)
+				return 0, nil, nil
+			}
+
+			if junkSize > 0 || carry != nil {
+				return emit(abandon(junk, data[:junkSize]), junkSize, res.Protocol)
+			}
+
+			scanner.SetResult(res)
+			if res.Advance > 0 {
+				// 报文已被消费, 错误随结果返回, 不中断扫描
+				return res.Advance, res.Token, nil
 			}
+			return res.Advance, res.Token, res.Error
 		}
 
-		// 没有匹配到就丢弃第一个数据
-		res = AbandonFramingRuleMatchResult(1, data)
-		scanner.SetResult(res)
-		return 1, []byte{data[0]}, nil // 脏数据处理
+		// 全部是脏数据
+		return emit(abandon(junk, data), len(data), nil)
+	}
+
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		advance, token, err = split(data, atEOF)
+		if advance > 0 || err != nil {
+			// 数据已被消费, 下一个报文重新计时
+			pending = nil
+		}
+		return advance, token, err
 	}
 }
@@ -0,0 +1,50 @@
+package core
+
+import "sync/atomic"
+
+// CodecStats 扫描统计
+type CodecStats struct {
+	Frames         int64 `json:"frames"`         // 成功解码的报文数
+	Errors         int64 `json:"errors"`         // 分包或解码失败的报文数
+	Abandoned      int64 `json:"abandoned"`      // 丢弃的结果数, 连续的脏数据算一次
+	AbandonedBytes int64 `json:"abandonedBytes"` // 丢弃的字节数
+	Resyncs        int64 `json:"resyncs"`        // 丢弃后重新同步到报文的次数
+}
+
+type codecStats struct {
+	frames         atomic.Int64
+	errors         atomic.Int64
+	abandoned      atomic.Int64
+	abandonedBytes atomic.Int64
+	resyncs        atomic.Int64
+}
+
+func (this *codecStats) add(result *ScanResult) {
+	switch {
+	case result.Abaddon:
+		this.abandoned.Add(1)
+		this.abandonedBytes.Add(int64(result.AbandonedBytes))
+		if result.ResyncProtocol != nil {
+			this.resyncs.Add(1)
+		}
+	case result.ScanError != nil:
+		this.errors.Add(1)
+	default:
+		this.frames.Add(1)
+	}
+}
+
+func (this *codecStats) snapshot() CodecStats {
+	return CodecStats{
+		Frames:         this.frames.Load(),
+		Errors:         this.errors.Load(),
+		Abandoned:      this.abandoned.Load(),
+		AbandonedBytes: this.abandonedBytes.Load(),
+		Resyncs:        this.resyncs.Load(),
+	}
+}
+
+// Stats 获取扫描统计, 可以在扫描过程中调用
+func (this *Codec) Stats() CodecStats {
+	return this.stats.snapshot()
+}
@@ -58,8 +58,22 @@ var Crc16Map = map[string]int{
 	KEY_XMODEM:      22,
 }
 
-// Crc 计算crc, name的格式 xxx_xxx, 如crc16_modbus
+const (
+	KEY_LRC  = "lrc"
+	KEY_SUM8 = "sum8"
+	KEY_XOR8 = "xor8"
+)
+
+// Crc 计算crc, name的格式 xxx_xxx, 如crc16_modbus, 也支持 lrc, sum8, xor8
 func Crc(data []byte, name string) (uint64, error) {
+	switch name {
+	case KEY_LRC:
+		return uint64(Lrc(data)), nil
+	case KEY_SUM8:
+		return uint64(Sum8(data)), nil
+	case KEY_XOR8:
+		return uint64(Xor8(data)), nil
+	}
 	parts := strings.SplitN(name, "_", 2)
 	if len(parts) < 2 {
 		return 0, errors.Errorf("invalid crc name: %s", name)
@@ -74,6 +88,20 @@ func Crc(data []byte, name string) (uint64, error) {
 	}
 }
 
+// CrcSize 获取校验值的字节数
+func CrcSize(name string) (int, error) {
+	switch name {
+	case KEY_LRC, KEY_SUM8, KEY_XOR8:
+		return 1, nil
+	}
+	if strings.HasPrefix(name, "crc16_") {
+		if _, ok := Crc16Map[strings.TrimPrefix(name, "crc16_")]; ok {
+			return 2, nil
+		}
+	}
+	return 0, errors.Errorf("unsupport crc: %s", name)
+}
+
 func Crc16(data []byte, key string) (uint64, error) {
 	if len(data) == 0 {
 		return 0, nil
@@ -84,3 +112,30 @@ func Crc16(data []byte, key string) (uint64, error) {
 	}
 	return uint64(crc16.Checksum(data, n)), nil
 }
+
+// Lrc 纵向冗余校验(Modbus ASCII 使用), 所有字节求和后取补码
+func Lrc(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+// Sum8 所有字节求和, 取低8位
+func Sum8(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// Xor8 所有字节异或
+func Xor8(data []byte) byte {
+	var x byte
+	for _, b := range data {
+		x ^= b
+	}
+	return x
+}
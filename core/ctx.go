@@ -7,19 +7,23 @@ import (
 	"github.com/vuuvv/errors"
 	"github.com/vuuvv/vpacket/utils"
 	"math"
+	"strconv"
 	"strings"
 )
 
 const (
 	FlowEncode = "encode"
 	FlowDecode = "decode"
+
+	BitOrderMsb = "msb" // 高位在前, 默认
+	BitOrderLsb = "lsb" // 低位在前
 )
 
 type Context struct {
 	Writer      bytes.Buffer
 	Data        []byte // 解析时使用
 	BytePos     int
-	BitPos      int
+	BitPos      int            // 当前字节中已经读/写的位数
 	Fields      map[string]any // 字段值
 	Vars        map[string]any // 变量值
 	Offsets     map[string]int // 字段的偏移量
@@ -27,9 +31,25 @@ type Context struct {
 	Round       int            // 编码的第几轮
 	NodeOffsets []int          // 正在处理的node的索引, 每个node有一个起始位置
 	NodeIndex   int
-	ArrayStack  [][]any // 当前元素所在的数组
-	ArrayDeep   int     // 是否在数组中
-	Array       []any   // 当前的Array
+	Scopes      []*Scope // 数组元素和结构体的作用域, 最后一个为当前作用域
+	Scheme      *Scheme  // 当前协议所在的 scheme, 可能为空
+}
+
+// Scope 数组元素或结构体的作用域, 其中的字段名相对于当前元素或结构体
+// 为了兼容, 以数组名(结构体名)为前缀的字段名会去掉前缀, 和数组名相同的字段名表示元素本身
+type Scope struct {
+	Name   string // 数组或结构体的字段名
+	Index  int    // 元素的索引
+	Value  any    // 元素的值, 有子字段时为 map[string]any
+	Struct bool   // 是否为结构体的作用域
+}
+
+// relative 字段名相对于元素的路径, 为空表示元素本身
+func (s *Scope) relative(name string) string {
+	if name == s.Name {
+		return ""
+	}
+	return strings.TrimPrefix(name, s.Name+".")
 }
 
 func NewContext(data []byte) *Context {
@@ -41,26 +61,96 @@ func NewContext(data []byte) *Context {
 	}
 }
 
+// Fork 创建处理另一段数据的上下文, 继承流程和 scheme
+func (c *Context) Fork(data []byte) *Context {
+	ctx := NewContext(data)
+	ctx.Flow = c.Flow
+	ctx.Scheme = c.Scheme
+	return ctx
+}
+
 func (c *Context) InArray() bool {
-	return len(c.ArrayStack) > 0
+	for _, scope := range c.Scopes {
+		if !scope.Struct {
+			return true
+		}
+	}
+	return false
+}
+
+// PushScope 进入数组元素的作用域, value 为编码时的元素值, 解码时为 nil
+func (c *Context) PushScope(name string, index int, value any) *Scope {
+	scope := &Scope{Name: name, Index: index, Value: value}
+	c.Scopes = append(c.Scopes, scope)
+	return scope
+}
+
+// PushStructScope 进入结构体的作用域, value 为结构体的字段
+func (c *Context) PushStructScope(name string, value map[string]any) *Scope {
+	scope := &Scope{Name: name, Value: value, Struct: true}
+	c.Scopes = append(c.Scopes, scope)
+	return scope
+}
+
+// PopScope 离开当前的作用域
+func (c *Context) PopScope() {
+	c.Scopes = c.Scopes[:len(c.Scopes)-1]
+}
+
+// scopeValues 表达式中的 self(当前作用域), parent(上一层作用域或根字段), item 和 index(所在的数组元素), 不在作用域中时为空
+func (c *Context) scopeValues() map[string]any {
+	n := len(c.Scopes)
+	if n == 0 {
+		return nil
+	}
+	values := map[string]any{"self": c.Scopes[n-1].Value, "parent": c.Fields}
+	if n > 1 {
+		values["parent"] = c.Scopes[n-2].Value
+	}
+	for i := n - 1; i >= 0; i-- {
+		if !c.Scopes[i].Struct {
+			values["item"], values["index"] = c.Scopes[i].Value, c.Scopes[i].Index
+			break
+		}
+	}
+	return values
 }
 
 // SetField 将 value 嵌套地放入 dict 中。
 // name 是一个用 "." 分割的路径字符串，例如 "a.b.c"。
 // 如果路径中的中间 map 不存在，该函数会自动创建它们。
 // 如果路径中的中间某个 key 对应的值存在但不是 map (例如是个 int)，它会被新的 map 覆盖以继续路径。
+// 在数组元素中, name 相对于当前元素
 func (c *Context) SetField(name string, value any) {
 	// 1. 如果 name 为空字符串，直接返回，不做任何操作 (或者可以根据需求决定是否允许空 key)
 	if name == "" {
 		return
 	}
 
+	dict := c.Fields
+	if len(c.Scopes) > 0 {
+		scope := c.Scopes[len(c.Scopes)-1]
+		if name = scope.relative(name); name == "" {
+			scope.Value = value
+			return
+		}
+		var ok bool
+		if dict, ok = scope.Value.(map[string]any); !ok {
+			dict = make(map[string]any)
+			scope.Value = dict
+		}
+	}
+	setPath(dict, name, value)
+}
+
+func setPath(dict map[string]any, name string, value any) {
+
 	// 2. 使用 "." 分割路径
 	keys := strings.Split(name, ".")
 
 	// currentMap 用来追踪当前正在处理的层级的 map。
 	// 初始时指向最外层的 dict。
-	currentMap := c.Fields
+	currentMap := dict
 
 	// 3. 遍历路径中的 key，除了最后一个。
 	// 这个循环的目标是确保通往最终目标的路径上的所有中间结构都存在且是 map。
@@ -96,6 +186,7 @@ func (c *Context) SetField(name string, value any) {
 // 返回值:
 // 1. any: 找到的值。如果未找到，则为 nil。
 // 2. bool: 一个布尔标志。true 表示找到了路径对应的值（即使该值本身是 nil）；false 表示路径不存在或中途断裂。
+// 在数组元素中, 先从当前元素查找, 找不到时依次从外层的元素和根字段查找
 func (c *Context) GetField(name string) (any, bool) {
 	// 1. 处理边界情况：如果路径为空，通常视为获取根 map 本身
 	if name == "" {
@@ -103,11 +194,66 @@ func (c *Context) GetField(name string) (any, bool) {
 		return c.Fields, true
 	}
 
+	for i := len(c.Scopes) - 1; i >= 0; i-- {
+		scope := c.Scopes[i]
+		rel := scope.relative(name)
+		if rel == "" {
+			return scope.Value, scope.Value != nil
+		}
+		if dict, ok := scope.Value.(map[string]any); ok {
+			if val, ok := getPath(dict, rel); ok {
+				return val, true
+			}
+		}
+	}
+	return getPath(c.Fields, name)
+}
+
+// IsScopeValue 字段名是否表示当前作用域的元素本身
+func (c *Context) IsScopeValue(name string) bool {
+	return len(c.Scopes) > 0 && c.Scopes[len(c.Scopes)-1].relative(name) == ""
+}
+
+// GetLocalField 只在当前作用域中查找字段, 不查找外层, 节点编码时用它读取自己的值
+func (c *Context) GetLocalField(name string) (any, bool) {
+	if len(c.Scopes) == 0 {
+		return getPath(c.Fields, name)
+	}
+	scope := c.Scopes[len(c.Scopes)-1]
+	rel := scope.relative(name)
+	if rel == "" {
+		return scope.Value, scope.Value != nil
+	}
+	dict, ok := scope.Value.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return getPath(dict, rel)
+}
+
+// FieldMissing 编码时节点没有找到自己的值, 所在的数组元素有输入时返回错误, 不会从外层或根字段借用同名的值
+// 不在数组中或元素没有输入时返回 nil, 由节点写入默认值或占位符
+func (c *Context) FieldMissing(name string) error {
+	for i := len(c.Scopes) - 1; i >= 0; i-- {
+		scope := c.Scopes[i]
+		if scope.Struct {
+			continue
+		}
+		if scope.Value == nil {
+			return nil
+		}
+		return errors.Errorf("array %s item %d: field %s missing", scope.Name, scope.Index, name)
+	}
+	return nil
+}
+
+func getPath(dict map[string]any, name string) (any, bool) {
+
 	// 2. 使用 "." 分割路径
 	keys := strings.Split(name, ".")
 
 	// 3. 初始化当前 map 指针指向根 dict
-	currentMap := c.Fields
+	currentMap := dict
 
 	// 4. 遍历所有的 key
 	for i, key := range keys {
@@ -178,44 +324,65 @@ func (ctx *Context) GetSize(size int, sizeExpr *CelEvaluator) (int, error) {
 	return size, nil
 }
 
+// ReadBits 按高位在前读取 n 位
 func (c *Context) ReadBits(n int) (uint64, error) {
+	return c.ReadBitsWithOrder(n, BitOrderMsb)
+}
+
+// ReadBitsWithOrder 读取 n 位, 可以跨字节
+// msb: 从字节的高位开始读, 先读到的位是结果的高位; lsb: 从字节的低位开始读, 先读到的位是结果的低位
+func (c *Context) ReadBitsWithOrder(n int, order string) (uint64, error) {
 	if n == 0 {
 		return 0, nil
 	}
-	if n > 64 {
-		return 0, errors.New("cannot read more than 64 bits")
+	if n < 0 || n > 64 {
+		return 0, errors.Errorf("bits should between 1 and 64, actual %d", n)
 	}
-	if c.BytePos >= len(c.Data) {
-		return 0, errors.New("EOF")
+	if (len(c.Data)-c.BytePos)*8-c.BitPos < n {
+		return 0, errors.Errorf("EOF reading bits, need %d, have %d", n, (len(c.Data)-c.BytePos)*8-c.BitPos)
 	}
 
-	// 计算可以读取的完整字节数和剩余位数
-	fullBytes := (n + c.BitPos) / 8
-	remainingBits := (n + c.BitPos) % 8
-
-	// 检查是否有足够的数据
-	if c.BytePos+fullBytes+utils.BoolToInt(remainingBits > 0) > len(c.Data) {
-		return 0, errors.New("unexpected EOF inside bits")
+	var value uint64
+	for i := 0; i < n; i++ {
+		b := c.Data[c.BytePos]
+		if order == BitOrderLsb {
+			value |= uint64(b>>c.BitPos&1) << i
+		} else {
+			value = value<<1 | uint64(b>>(7-c.BitPos)&1)
+		}
+		c.BitPos++
+		if c.BitPos == 8 {
+			c.BitPos = 0
+			c.BytePos++
+		}
 	}
+	return value, nil
+}
 
-	var value uint64 = 0
-	// 读取完整字节
-	for i := 0; i < fullBytes; i++ {
-		value = (value << 8) | uint64(c.Data[c.BytePos])
-		c.BytePos++
+// WriteBits 写入 value 的低 n 位, 位的顺序和 ReadBitsWithOrder 相同, 没有写满的字节由后面的位继续填充
+// 只在第一轮编码中写入, 位字段不支持回填
+func (c *Context) WriteBits(value uint64, n int, order string) error {
+	if n <= 0 || n > 64 {
+		return errors.Errorf("bits should between 1 and 64, actual %d", n)
 	}
-
-	// 读取剩余位
-	if remainingBits > 0 {
-		value = (value << remainingBits) | uint64(c.Data[c.BytePos]>>(8-remainingBits))
-		c.BitPos = remainingBits
-		c.BytePos += utils.BoolToInt(c.BitPos == 8)
-		c.BitPos %= 8
-	} else {
-		c.BitPos = 0
+	if c.Round != 0 {
+		return errors.New("bits can only be written in round 0")
 	}
-
-	return value, nil
+	for i := 0; i < n; i++ {
+		if c.BitPos == 0 {
+			c.Writer.WriteByte(0)
+		}
+		var bit byte
+		if order == BitOrderLsb {
+			bit = byte(value>>i&1) << c.BitPos
+		} else {
+			bit = byte(value>>(n-1-i)&1) << (7 - c.BitPos)
+		}
+		bs := c.Writer.Bytes()
+		bs[len(bs)-1] |= bit
+		c.BitPos = (c.BitPos + 1) % 8
+	}
+	return nil
 }
 
 func (c *Context) ReadBytes(n int) ([]byte, error) {
@@ -237,36 +404,33 @@ func (c *Context) ReadBytes(n int) ([]byte, error) {
 }
 
 func (w *Context) WriteInt(value uint64, size int, byteOrder binary.ByteOrder) error {
-	buf := make([]byte, 8)
-	byteOrder.PutUint64(buf, value)
-
-	// 写入最后 numBytes 个字节
-	switch byteOrder {
-	case binary.BigEndian:
-		return w.WriteBytes(buf[8-size:])
-	case binary.LittleEndian:
-		return w.WriteBytes(buf[:size])
+	if size < 1 || size > 8 {
+		return errors.Errorf("int size should between 1 and 8, actual %d", size)
 	}
-	return nil
+	return w.WriteBytes(utils.Uint64ToBytes(value, size, byteOrder))
 }
 
+// WriteFloat 按 size 写入 IEEE-754 浮点数, 2: 半精度, 4: 单精度, 8: 双精度
 func (w *Context) WriteFloat(value float64, size int, byteOrder binary.ByteOrder) error {
-	buf := bytes.Buffer{}
-	err := binary.Write(&buf, byteOrder, value)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	switch byteOrder {
-	case binary.BigEndian:
-		return w.WriteBytes(buf.Bytes()[8-size:])
-	case binary.LittleEndian:
-		return w.WriteBytes(buf.Bytes()[:size])
+	var bits uint64
+	switch size {
+	case 2:
+		bits = uint64(utils.Float32ToFloat16(float32(value)))
+	case 4:
+		bits = uint64(math.Float32bits(float32(value)))
+	case 8:
+		bits = math.Float64bits(value)
+	default:
+		return errors.Errorf("float size should be 2, 4 or 8, actual %d", size)
 	}
-	return nil
+	return w.WriteBytes(utils.Uint64ToBytes(bits, size, byteOrder))
 }
 
 // WriteBytes 写入完整的字节
 func (w *Context) WriteBytes(data []byte) error {
+	if w.BitPos != 0 {
+		return errors.New("write bytes must be aligned")
+	}
 	if w.Round == 0 {
 		_, err := w.Writer.Write(data)
 		return err
@@ -293,11 +457,13 @@ func (w *Context) Write(typ string, val any, size int, encodable Encodable) erro
 	case NodeTypeString:
 		return w.writeString(val, size, encodable.GetPadByte(), encodable.GetPadPosition())
 	case NodeTypeInt:
-		return w.writeUint(val, size, encodable.GetByteOrder())
+		return w.writeInt(val, size, encodable.GetByteOrder())
 	case NodeTypeUint:
 		return w.writeUint(val, size, encodable.GetByteOrder())
 	case NodeTypeFloat:
 		return w.writeFloat(val, size, encodable.GetByteOrder())
+	case NodeTypeBcd, NodeTypePacked, NodeTypeBcdString:
+		return w.writeBcd(typ, val, size, encodable.GetByteOrder())
 	}
 	return nil
 }
@@ -325,11 +491,61 @@ func (ctx *Context) writeString(val any, size int, padByte byte, padPosition str
 }
 
 func (ctx *Context) writeUint(val any, size int, byteOrder binary.ByteOrder) error {
-	i, ok := utils.ToUint64(val)
+	if size < 1 || size > 8 {
+		return errors.Errorf("uint size should between 1 and 8, actual %d", size)
+	}
+	u, err := utils.UintToBits(val, size*8)
+	if err != nil {
+		return err
+	}
+	return ctx.WriteInt(u, size, byteOrder)
+}
+
+// writeInt 写入有符号整数的补码
+func (ctx *Context) writeInt(val any, size int, byteOrder binary.ByteOrder) error {
+	if size < 1 || size > 8 {
+		return errors.Errorf("int size should between 1 and 8, actual %d", size)
+	}
+	i, ok := utils.ToInt64(val)
 	if !ok {
 		return errors.Errorf("value should be a int, '%v'", val)
 	}
-	return ctx.WriteInt(i, size, byteOrder)
+	u, err := utils.IntToBits(i, size*8)
+	if err != nil {
+		return err
+	}
+	return ctx.WriteInt(u, size, byteOrder)
+}
+
+// writeBcd 写入 BCD 码, 小端时字节逆序
+func (ctx *Context) writeBcd(typ string, val any, size int, byteOrder binary.ByteOrder) error {
+	var bs []byte
+	var err error
+	switch typ {
+	case NodeTypeBcdString:
+		str, ok := val.(string)
+		if !ok {
+			return errors.Errorf("value should be a string, '%v'", val)
+		}
+		bs, err = utils.EncodeBcd(str, size)
+	case NodeTypePacked:
+		i, ok := utils.ToInt64(val)
+		if !ok {
+			return errors.Errorf("value should be a int, '%v'", val)
+		}
+		digits := strconv.FormatInt(i, 10)
+		bs, err = utils.EncodePackedBcd(strings.TrimPrefix(digits, "-"), i < 0, size)
+	default:
+		u, e := utils.UintToBits(val, 64)
+		if e != nil {
+			return e
+		}
+		bs, err = utils.EncodeBcd(strconv.FormatUint(u, 10), size)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.WriteBytes(utils.ReorderBytes(bs, byteOrder))
 }
 
 func (ctx *Context) writeFloat(val any, size int, byteOrder binary.ByteOrder) error {
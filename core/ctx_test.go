@@ -0,0 +1,51 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBits(t *testing.T) {
+	cases := []struct {
+		order  string
+		bits   []int
+		values []uint64
+		data   []byte
+	}{
+		// 101 | 11110000 | 1111 | 0000, 跨字节
+		{BitOrderMsb, []int{3, 8, 4, 1}, []uint64{0b101, 0xf0, 0xf, 0}, []byte{0b10111110, 0b00011110}},
+		// 第一个位在最低位
+		{BitOrderLsb, []int{3, 8, 4, 1}, []uint64{0b101, 0xf0, 0xf, 0}, []byte{0b10000101, 0b01111111}},
+	}
+	for _, c := range cases {
+		writer := NewContext(nil)
+		for i, n := range c.bits {
+			if err := writer.WriteBits(c.values[i], n, c.order); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+		if !bytes.Equal(writer.Writer.Bytes(), c.data) {
+			t.Fatalf("%s: expect %08b, got %08b", c.order, c.data, writer.Writer.Bytes())
+		}
+
+		reader := NewContext(c.data)
+		for i, n := range c.bits {
+			v, err := reader.ReadBitsWithOrder(n, c.order)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if v != c.values[i] {
+				t.Fatalf("%s: bits %d expect %b, got %b", c.order, i, c.values[i], v)
+			}
+		}
+		if _, err := reader.ReadBitsWithOrder(1, c.order); err == nil {
+			t.Fatalf("%s: expect EOF error", c.order)
+		}
+	}
+
+	ctx := NewContext(nil)
+	_ = ctx.WriteBits(1, 3, BitOrderMsb)
+	if err := ctx.WriteBytes([]byte{1}); err == nil {
+		t.Fatal("expect error for unaligned bytes")
+	}
+}
@@ -2,6 +2,20 @@ package core
 
 type DataStructure struct {
 	Fields []*YamlField `yaml:"fields"`
+	Flags  []*Flag      `yaml:"flags"` // 标志位定义, 用于 flags 节点
+	Enum   []*EnumItem  `yaml:"enum"`  // 值到标签的映射, 用于字段的 enum 属性
+}
+
+// EnumItem 枚举项
+type EnumItem struct {
+	Value any    `yaml:"value"`
+	Label string `yaml:"label"`
+}
+
+// Flag 标志位, bit 从最低位 0 开始
+type Flag struct {
+	Bit  int    `yaml:"bit"`
+	Name string `yaml:"name"`
 }
 
 type DataStructures map[string]*DataStructure
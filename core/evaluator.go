@@ -13,6 +13,10 @@ func CompileExpression(expr string) (*CelEvaluator, error) {
 		cel.Variable("fields", cel.MapType(cel.StringType, cel.DynType)),  // fields为当前字段的所有值
 		cel.Variable("offsets", cel.MapType(cel.StringType, cel.DynType)), // fields为当前字段的所有值
 		cel.Variable("val", cel.DynType),                                  // val为当前字段的值
+		cel.Variable("item", cel.DynType),                                 // item为数组中的当前元素
+		cel.Variable("index", cel.IntType),                                // index为数组中当前元素的索引
+		cel.Variable("parent", cel.DynType),                               // parent为当前作用域的上一层, 元素, 结构体或根字段
+		cel.Variable("self", cel.DynType),                                 // self为当前作用域, 数组元素或结构体
 	)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -43,11 +47,22 @@ func CompileExpression(expr string) (*CelEvaluator, error) {
 //}
 
 func (e *CelEvaluator) Execute(ctx *Context) (any, error) {
+	return e.ExecuteWithValue(ctx, nil)
+}
+
+// ExecuteWithValue 计算表达式, currentVal 不为 nil 时作为 val 变量
+func (e *CelEvaluator) ExecuteWithValue(ctx *Context, currentVal any) (any, error) {
 	input := map[string]any{
 		"vars":    ctx.Vars,
 		"fields":  ctx.Fields,
 		"offsets": ctx.Offsets,
 	}
+	if currentVal != nil {
+		input["val"] = currentVal
+	}
+	for k, v := range ctx.scopeValues() {
+		input[k] = v
+	}
 	out, _, err := e.prg.Eval(input)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -17,6 +17,7 @@ type YamlSwitchCase struct {
 	Ref    string       `yaml:"ref"`    // 外部引用
 	Fields []*YamlField `yaml:"fields"` // 内联定义
 	Value  any          `yaml:"value"`
+	Name   string       `yaml:"name"` // tlv 中 tag 的名称
 }
 
 type YamlField struct {
@@ -24,11 +25,17 @@ type YamlField struct {
 	Flow        string       `yaml:"flow"` // 流程类型, 为空表示所有流程都包括, 其它的有 "encode", "decode"
 	Round       int          `yaml:"round"`
 	Bits        int          `yaml:"bits"`
+	BitOrder    string       `yaml:"bit_order"` // 位的顺序, msb: 高位在前, lsb: 低位在前, 默认 msb
 	Type        string       `yaml:"type"`
 	Size        int          `yaml:"size"`
 	SizeExpr    string       `yaml:"size_expr"`
-	Default     yaml.Node    `yaml:"default"` // 默认值
-	Endian      string       `yaml:"endian"`  // 字节序, big: 大端, little: 小端, 默认大端
+	Default     yaml.Node    `yaml:"default"`      // 默认值
+	Endian      string       `yaml:"endian"`       // 字节序, big/abcd: 大端, little/dcba: 小端, cdab: 字逆序, badc: 字内字节交换, 默认大端
+	Scale       float64      `yaml:"scale"`        // 数值的比例, 解码值 = 原始值 * scale + offset, 为 0 时不缩放
+	Offset      float64      `yaml:"offset"`       // 数值的偏移
+	Decimals    int          `yaml:"decimals"`     // 隐含的小数位数, 如 bcd 的 002315 为 23.15, 和 scale 不能同时设置
+	Enum        string       `yaml:"enum"`         // 引用 data_structures 中的 enum, 字段保留原始值, 标签保存在 "字段名_label" 中
+	EnumUnknown string       `yaml:"enum_unknown"` // 值不在 enum 中时的处理: raw(默认), null, error
 	PadByte     string       `yaml:"pad_byte"`
 	PadPosition string       `yaml:"pad_position"`
 	Check       string       `yaml:"check"`
@@ -50,8 +57,31 @@ type YamlField struct {
 	// struct
 	Ref string `yaml:"ref"` // 结构定义
 
+	// flags
+	Flags []*Flag `yaml:"flags"` // 内联的标志位定义, 和 ref 二选一
+
+	// datetime
+	Format   string `yaml:"format"`   // 日期时间的格式: unix, unix_ms, bcd, binary, 默认 unix
+	Timezone string `yaml:"timezone"` // 时区, 如 Asia/Shanghai, 默认本地时区
+	Output   string `yaml:"output"`   // 解码的输出: rfc3339, time, 默认 rfc3339
+
+	// tlv
+	TagSize    int `yaml:"tag_size"`    // tag 的字节数, 默认 1
+	LengthSize int `yaml:"length_size"` // length 的字节数, 默认 1
+
+	// tunnel
+	Protocol string `yaml:"protocol"` // 使用 scheme 中的其它协议解码
+
+	// struct
+	Namespace bool `yaml:"namespace"` // 结构体是否打开以 name 为前缀的命名空间, 默认 false, 子字段使用完整的字段名
+
 	// array
-	Fields []*YamlField `yaml:"fields"` //
+	Fields       []*YamlField `yaml:"fields"`         //
+	Repeat       string       `yaml:"repeat"`         // 数组的循环方式: 为空时按 size/size_expr 的个数, eos: 直到数据结束, until: 直到 until 条件成立或遇到 terminator
+	Until        string       `yaml:"until"`          // 每个元素解码后计算的 CEL 表达式, val 为最后一个元素, 为 true 时结束
+	Terminator   string       `yaml:"terminator"`     // 结束符(hex), 解码时遇到结束符则结束并跳过结束符, 编码时写在所有元素之后
+	ByteSizeExpr string       `yaml:"byte_size_expr"` // 数组占用的字节数, 元素循环到用完这些字节为止
+	MaxItems     int          `yaml:"max_items"`      // 元素个数的上限, 防止死循环, 默认 65535
 
 	//// array
 	//Item *YamlStructDef `yaml:"item"` // 数组元素定义
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"encoding/binary"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/utils"
+)
+
+// FrameChecksum 报文级别的校验, 由分包规则在分包时校验, 校验失败时丢弃一个字节重新同步, 编码时自动填充
+// 位置和范围都是针对分包规则返回的 token(即 fields 看到的数据), 负数表示从报文末尾开始计算, 如 -2 表示倒数第二个字节
+type FrameChecksum struct {
+	Algorithm string `yaml:"algorithm"` // 校验算法, 同 crc 属性, 如 crc16_modbus, lrc, sum8, xor8
+	Start     int    `yaml:"start"`     // 参与校验的数据起始位置, 默认为 0
+	End       *int   `yaml:"end"`       // 参与校验的数据结束位置(不包含), 默认为校验值的位置
+	Offset    *int   `yaml:"offset"`    // 校验值的位置, 默认在报文末尾
+	Size      int    `yaml:"size"`      // 校验值的字节数, 默认由算法决定
+	Endian    string `yaml:"endian"`    // 校验值的字节序, big(默认) 或 little
+	byteOrder binary.ByteOrder
+}
+
+func (this *FrameChecksum) Setup() error {
+	if this.Algorithm == "" {
+		return errors.New("FrameChecksum.Setup: algorithm should not be empty")
+	}
+	size, err := CrcSize(this.Algorithm)
+	if err != nil {
+		return errors.Wrapf(err, "FrameChecksum.Setup: invalid algorithm '%s'", this.Algorithm)
+	}
+	if this.Size == 0 {
+		this.Size = size
+	}
+	if this.Size < size || this.Size > 8 {
+		return errors.Errorf("FrameChecksum.Setup: size should between %d and 8, actual %d", size, this.Size)
+	}
+	switch this.Endian {
+	case "", "big", "little":
+	default:
+		return errors.Errorf("FrameChecksum.Setup: unsupported endian '%s', should be big or little", this.Endian)
+	}
+	this.byteOrder = utils.GetByteOrder(this.Endian)
+	return nil
+}
+
+// Verify 校验报文
+func (this *FrameChecksum) Verify(frame []byte) error {
+	start, end, offset, err := this.resolve(len(frame))
+	if err != nil {
+		return err
+	}
+	expect, err := Crc(frame[start:end], this.Algorithm)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	actual, err := utils.ConvertBytesToInt(frame[offset:offset+this.Size], this.byteOrder)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if expect != actual {
+		return errors.Errorf("frame checksum %s failed, expect '%X', actual '%X'", this.Algorithm, expect, actual)
+	}
+	return nil
+}
+
+// Fill 计算校验值并写入报文, 会修改 frame
+func (this *FrameChecksum) Fill(frame []byte) error {
+	start, end, offset, err := this.resolve(len(frame))
+	if err != nil {
+		return err
+	}
+	crc, err := Crc(frame[start:end], this.Algorithm)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	copy(frame[offset:offset+this.Size], utils.Uint64ToBytes(crc, this.Size, this.byteOrder))
+	return nil
+}
+
+// resolve 根据报文长度计算校验范围和校验值的位置
+func (this *FrameChecksum) resolve(n int) (start int, end int, offset int, err error) {
+	offset = n - this.Size
+	if this.Offset != nil {
+		offset = relativeIndex(*this.Offset, n)
+	}
+	start = relativeIndex(this.Start, n)
+	end = offset
+	if this.End != nil {
+		end = relativeIndex(*this.End, n)
+	}
+	if offset < 0 || offset+this.Size > n || start < 0 || start > end || end > n {
+		return 0, 0, 0, errors.Errorf("frame length %d is too short for checksum", n)
+	}
+	return start, end, offset, nil
+}
+
+func relativeIndex(i int, n int) int {
+	if i < 0 {
+		return n + i
+	}
+	return i
+}
@@ -14,6 +14,12 @@ type FramingRuleMatchResult struct {
 	Token     []byte
 	Error     error
 	Time      time.Time
+	Decoded   bool // 分包时已经试解码, Data 为解码结果
+	Data      any
+	// 以下为合并的丢弃结果使用, Time 为开始丢弃的时间
+	EndTime        time.Time           // 重新同步的时间
+	ResyncProtocol *Protocol           // 丢弃后重新同步到的协议, 数据全部丢弃时为空
+	Rejected       []*RejectedProtocol // trial_decode 时试解码失败的协议
 }
 
 func NewFramingRuleMatchResult(advance int, token []byte) *FramingRuleMatchResult {
@@ -29,7 +35,7 @@ func AbandonFramingRuleMatchResult(size int, data []byte) *FramingRuleMatchResul
 	return &FramingRuleMatchResult{
 		Abandoned: true,
 		Advance:   size,
-		Token:     []byte{data[0]},
+		Token:     data[:size],
 		Time:      time.Now(),
 	}
 }
@@ -47,12 +53,28 @@ func ErrorFramingRuleMatchResult(err error) *FramingRuleMatchResult {
 	}
 }
 
+// InvalidFramingRuleMatchResult 表示找到了报文边界, 但报文本身不合法(如转义错误), 报文会被消费并作为错误结果返回
+func InvalidFramingRuleMatchResult(advance int, token []byte, err error) *FramingRuleMatchResult {
+	return &FramingRuleMatchResult{
+		Advance: advance,
+		Token:   token,
+		Error:   err,
+		Time:    time.Now(),
+	}
+}
+
 type FramingRule interface {
 	Split(data []byte) *FramingRuleMatchResult
 	GetHeaderMarker() []byte
 	Setup() error
 }
 
+// FramingEncoder 分包规则的可选接口, 在 fields 编码完成后对报文进行封装, 如添加分隔符, 转义, 校验码等,
+// 是 Split 的逆操作: Split 返回的 token 经过 Encode 后应该得到原始报文. 不需要封装的分包规则可以不实现
+type FramingEncoder interface {
+	Encode(payload []byte) ([]byte, error)
+}
+
 var FramingRuleDecoders = make(map[string]FramingRuleDecodeFunc)
 
 type FramingRuleDecodeFunc func(yamlNode *yaml.Node) (FramingRule, error)
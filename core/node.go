@@ -7,17 +7,25 @@ import (
 )
 
 const (
-	NodeTypeBytes  = "bytes"  // 默认类型
-	NodeTypeCalc   = "calc"   // 计算类型
-	NodeTypeIf     = "if"     // 条件类型
-	NodeTypeSwitch = "switch" // switch类型
-	NodeTypeArray  = "array"  // 数组类型
-	NodeTypeStruct = "struct" // 结构类型,嵌套
-	NodeTypeHex    = "hex"
-	NodeTypeString = "string"
-	NodeTypeInt    = "int"
-	NodeTypeUint   = "uint"
-	NodeTypeFloat  = "float"
+	NodeTypeBytes     = "bytes"    // 默认类型
+	NodeTypeCalc      = "calc"     // 计算类型
+	NodeTypeIf        = "if"       // 条件类型
+	NodeTypeSwitch    = "switch"   // switch类型
+	NodeTypeArray     = "array"    // 数组类型
+	NodeTypeStruct    = "struct"   // 结构类型,嵌套
+	NodeTypeTunnel    = "tunnel"   // 使用其它协议或结构解码一段数据
+	NodeTypeDatetime  = "datetime" // 日期时间
+	NodeTypeBitfield  = "bitfield" // 位域, 多个按位的子字段
+	NodeTypeFlags     = "flags"    // 标志位, 解码为置位的标志名列表和原始值
+	NodeTypeTlv       = "tlv"      // tag-length-value 列表
+	NodeTypeHex       = "hex"
+	NodeTypeString    = "string"
+	NodeTypeInt       = "int"
+	NodeTypeUint      = "uint"
+	NodeTypeFloat     = "float"
+	NodeTypeBcd       = "bcd"        // 无符号 BCD 码, 解码为整数
+	NodeTypePacked    = "packed"     // 带符号的压缩 BCD 码, 最后一个半字节为符号
+	NodeTypeBcdString = "bcd_string" // BCD 码的数字字符串, 保留前面的 0, 如电话号码
 )
 
 type Node interface {
@@ -192,6 +200,33 @@ func NodeEncode(ctx *Context, nodes ...Node) error {
 	return nil
 }
 
+// NodeEncodeRounds 分多轮编码, 后面的轮次回填前面轮次的占位符(如长度, CRC), 返回编码后的数据
+func NodeEncodeRounds(ctx *Context, round int, nodes ...Node) ([]byte, error) {
+	for i := 0; i <= round; i++ {
+		ctx.NodeIndex = 0
+		ctx.Round = i
+		err := NodeEncode(ctx, nodes...)
+		if i == 0 {
+			bs := ctx.Writer.Bytes()
+			ctx.Vars["packetLen"] = len(bs)
+			ctx.Data = bs
+		}
+		if err != nil {
+			return ctx.Data, err
+		}
+	}
+	return ctx.Data, nil
+}
+
+// NodeMaxRound 获取节点编码需要的最大轮次
+func NodeMaxRound(nodes []Node) int {
+	round := 0
+	for _, node := range nodes {
+		round = max(round, node.GetRound())
+	}
+	return round
+}
+
 func NodeDecode(ctx *Context, nodes ...Node) error {
 	for _, node := range nodes {
 		if !ctx.MatchFlow(node) {
@@ -3,20 +3,28 @@ package core
 import (
 	"github.com/vuuvv/errors"
 	"gopkg.in/yaml.v3"
+	"time"
 )
 
 type Protocol struct {
-	Name              string       `yaml:"name"`
-	Type              string       `yaml:"type"`
-	FramingRule       yaml.Node    `yaml:"framing_rule"`
-	Fields            []*YamlField `yaml:"fields"`
+	Name              string        `yaml:"name"`
+	Type              string        `yaml:"type"`
+	FramingRule       yaml.Node     `yaml:"framing_rule"`
+	Fields            []*YamlField  `yaml:"fields"`
+	Priority          int           `yaml:"priority"`      // 同一位置有多个协议匹配时, priority 大的优先尝试, 默认为 0
+	Reassembly        *Reassembly   `yaml:"reassembly"`    // 多帧重组, 为空时每个报文单独解码
+	FrameTimeout      time.Duration `yaml:"frame_timeout"` // 不完整报文的超时时间, 如 500ms, 超时后缓存的数据被丢弃, 为 0 时一直等待
 	ParsedFramingRule FramingRule
 	ParsedFields      []Node
 	Round             int
+	scheme            *Scheme // 协议所在的 scheme, tunnel 节点用来查找其它协议
 }
 
 // Setup 获取分包规则
 func (p *Protocol) Setup(structures DataStructures) error {
+	if p.FrameTimeout < 0 {
+		return errors.Errorf("Protocol '%s': frame_timeout should not be negative", p.Name)
+	}
 	if p.FramingRule.IsZero() {
 		return errors.New("framing_rule not set")
 	}
@@ -32,17 +40,18 @@ func (p *Protocol) Setup(structures DataStructures) error {
 	}
 
 	p.ParsedFields = fields
-	for _, field := range fields {
-		r := field.GetRound()
-		if r > p.Round {
-			p.Round = r
+	if p.Reassembly != nil {
+		if err = p.Reassembly.Setup(structures); err != nil {
+			return errors.Wrapf(err, "Protocol '%s' reassembly setup failed", p.Name)
 		}
 	}
+	p.Round = NodeMaxRound(fields)
 	return nil
 }
 
 func (p *Protocol) Decode(packet []byte) (any, error) {
 	ctx := NewContext(packet)
+	ctx.Scheme = p.scheme
 	ctx.Flow = FlowDecode
 	ctx.Vars["packetLen"] = len(packet)
 	err := NodeDecode(ctx, p.ParsedFields...)
@@ -50,18 +59,18 @@ func (p *Protocol) Decode(packet []byte) (any, error) {
 }
 
 func (p *Protocol) Encode(ctx *Context) ([]byte, error) {
-	for i := 0; i <= p.Round; i++ {
-		ctx.NodeIndex = 0
-		ctx.Round = i
-		err := NodeEncode(ctx, p.ParsedFields...)
-		if i == 0 {
-			bs := ctx.Writer.Bytes()
-			ctx.Vars["packetLen"] = len(bs)
-			ctx.Data = bs
-		}
+	if ctx.Scheme == nil {
+		ctx.Scheme = p.scheme
+	}
+	if _, err := NodeEncodeRounds(ctx, p.Round, p.ParsedFields...); err != nil {
+		return ctx.Data, errors.WithStack(err)
+	}
+	if encoder, ok := p.ParsedFramingRule.(FramingEncoder); ok {
+		bs, err := encoder.Encode(ctx.Data)
 		if err != nil {
-			return ctx.Data, errors.WithStack(err)
+			return ctx.Data, errors.Wrapf(err, "Protocol '%s' framing encode failed", p.Name)
 		}
+		return bs, nil
 	}
 	return ctx.Data, nil
 }
@@ -0,0 +1,327 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/utils"
+	"sort"
+	"time"
+)
+
+const (
+	defaultReassemblyTimeout     = 30 * time.Second
+	defaultReassemblyMaxMessages = 1024
+)
+
+// Reassembly 多帧重组, 一条消息被拆分到多个报文中(如 JT/T 808 的分包), 每个报文带有消息 id, 包序号和总包数
+// 每个报文先按协议的 fields 解码, 收齐所有分包后按包序号拼接 payload, 再按 ref/fields 解码拼接后的消息
+// message_id, index, total, condition 都是 CEL 表达式, 可以使用报文解码后的 fields
+type Reassembly struct {
+	Condition string        `yaml:"condition"`  // 报文是否为分包, 为空时所有报文都是分包
+	MessageId string        `yaml:"message_id"` // 消息 id, 同一消息的分包相同
+	Index     string        `yaml:"index"`      // 包序号
+	Total     string        `yaml:"total"`      // 总包数
+	Payload   string        `yaml:"payload"`    // 分包数据所在的字段, 字段类型须为 hex
+	IndexBase int           `yaml:"index_base"` // 包序号的起始值, 如 JT/T 808 为 1, 默认为 0
+	Timeout   time.Duration `yaml:"timeout"`    // 收齐所有分包的超时时间, 从收到第一个分包开始计时, 默认为 30s
+	MaxSize   int           `yaml:"max_size"`   // 拼接后消息的最大字节数, 默认为 utils.MaxScanTokenSize
+	// 同时缓存的未收齐消息的最大数量, 超过时移除最早的消息并返回错误结果, 默认为 1024
+	MaxMessages int          `yaml:"max_messages"`
+	Ref         string       `yaml:"ref"`    // 拼接后消息的结构定义
+	Fields      []*YamlField `yaml:"fields"` // 拼接后消息的内联定义
+	condition   *CelEvaluator
+	messageId   *CelEvaluator
+	index       *CelEvaluator
+	total       *CelEvaluator
+	nodes       []Node
+}
+
+func (this *Reassembly) Setup(structures DataStructures) (err error) {
+	if this.MessageId == "" || this.Index == "" || this.Total == "" || this.Payload == "" {
+		return errors.New("Reassembly.Setup: message_id, index, total and payload should not be empty")
+	}
+	if this.Condition != "" {
+		if this.condition, err = CompileExpression(this.Condition); err != nil {
+			return errors.Wrapf(err, "Reassembly.Setup: compile condition '%s' failed", this.Condition)
+		}
+	}
+	if this.messageId, err = CompileExpression(this.MessageId); err != nil {
+		return errors.Wrapf(err, "Reassembly.Setup: compile message_id '%s' failed", this.MessageId)
+	}
+	if this.index, err = CompileExpression(this.Index); err != nil {
+		return errors.Wrapf(err, "Reassembly.Setup: compile index '%s' failed", this.Index)
+	}
+	if this.total, err = CompileExpression(this.Total); err != nil {
+		return errors.Wrapf(err, "Reassembly.Setup: compile total '%s' failed", this.Total)
+	}
+	if this.Timeout < 0 {
+		return errors.Errorf("Reassembly.Setup: timeout should not be negative, actual %s", this.Timeout)
+	}
+	if this.Timeout == 0 {
+		this.Timeout = defaultReassemblyTimeout
+	}
+	if this.MaxSize < 0 || this.MaxSize > utils.MaxScanTokenSize {
+		return errors.Errorf("Reassembly.Setup: max_size should between 1 and %d, actual %d", utils.MaxScanTokenSize, this.MaxSize)
+	}
+	if this.MaxSize == 0 {
+		this.MaxSize = utils.MaxScanTokenSize
+	}
+	if this.MaxMessages < 0 {
+		return errors.Errorf("Reassembly.Setup: max_messages should not be negative, actual %d", this.MaxMessages)
+	}
+	if this.MaxMessages == 0 {
+		this.MaxMessages = defaultReassemblyMaxMessages
+	}
+	this.nodes, err = NodeCompileWithRef(this.Ref, this.Fields, structures, true)
+	if err != nil {
+		return errors.Wrapf(err, "Reassembly.Setup: compile message structure failed")
+	}
+	return nil
+}
+
+// fragment 从解码后的报文中获取分包信息, ok 为 false 表示不是分包
+func (this *Reassembly) fragment(fields map[string]any) (id string, index int, total int, payload []byte, ok bool, err error) {
+	ctx := NewContext(nil)
+	ctx.Fields = fields
+
+	if this.condition != nil {
+		res, err := this.condition.Execute(ctx)
+		if err != nil {
+			return "", 0, 0, nil, false, errors.Wrapf(err, "reassembly condition execute failed")
+		}
+		if b, _ := res.(bool); !b {
+			return "", 0, 0, nil, false, nil
+		}
+	}
+
+	res, err := this.messageId.Execute(ctx)
+	if err != nil {
+		return "", 0, 0, nil, false, errors.Wrapf(err, "reassembly message_id execute failed")
+	}
+	id = fmt.Sprint(res)
+
+	index, err = this.executeInt(ctx, this.index, "index")
+	if err != nil {
+		return "", 0, 0, nil, false, err
+	}
+	total, err = this.executeInt(ctx, this.total, "total")
+	if err != nil {
+		return "", 0, 0, nil, false, err
+	}
+	index -= this.IndexBase
+	if total < 1 || index < 0 || index >= total {
+		return "", 0, 0, nil, false, errors.Errorf("reassembly fragment index %d out of range, total %d", index+this.IndexBase, total)
+	}
+
+	val, found := ctx.GetField(this.Payload)
+	if !found {
+		return "", 0, 0, nil, false, errors.Errorf("reassembly payload field '%s' not found", this.Payload)
+	}
+	s, isString := val.(string)
+	if !isString {
+		return "", 0, 0, nil, false, errors.Errorf("reassembly payload field '%s' should be hex, actual %T", this.Payload, val)
+	}
+	payload, err = hex.DecodeString(s)
+	if err != nil {
+		return "", 0, 0, nil, false, errors.Wrapf(err, "reassembly payload field '%s' is not a valid hex", this.Payload)
+	}
+	return id, index, total, payload, true, nil
+}
+
+func (this *Reassembly) executeInt(ctx *Context, expr *CelEvaluator, name string) (int, error) {
+	res, err := expr.Execute(ctx)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reassembly %s execute failed", name)
+	}
+	v, ok := utils.ToUint64(res)
+	if !ok {
+		return 0, errors.Errorf("reassembly %s should be an integer, actual %v", name, res)
+	}
+	return int(v), nil
+}
+
+// decode 解码拼接后的消息, fields 从最后一个分包的 fields 开始
+func (this *Reassembly) decode(p *Protocol, message []byte, fields map[string]any) (map[string]any, error) {
+	ctx := NewContext(message)
+	ctx.Scheme = p.scheme
+	ctx.Flow = FlowDecode
+	ctx.Vars["packetLen"] = len(message)
+	for k, v := range fields {
+		ctx.Fields[k] = v
+	}
+	err := NodeDecode(ctx, this.nodes...)
+	return ctx.Fields, err
+}
+
+// fragmentBuffer 一条消息已收到的分包
+type fragmentBuffer struct {
+	protocol  *Protocol
+	id        string
+	total     int
+	fragments map[int][]byte
+	size      int
+	start     time.Time
+	seq       int            // 创建的顺序, 用于移除最早的消息
+	fields    map[string]any // 最后一个分包的 fields
+}
+
+func (this *fragmentBuffer) join() []byte {
+	indexes := make([]int, 0, len(this.fragments))
+	for i := range this.fragments {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	message := make([]byte, 0, this.size)
+	for _, i := range indexes {
+		message = append(message, this.fragments[i]...)
+	}
+	return message
+}
+
+// errorResult 消息重组失败, 返回已收到的数据
+func (this *fragmentBuffer) errorResult(err error) *ScanResult {
+	start := this.start
+	return &ScanResult{
+		Packet:    this.join(),
+		Protocol:  this.protocol,
+		Data:      this.fields,
+		ScanError: err,
+		Fragments: len(this.fragments),
+		Start:     &start,
+	}
+}
+
+// reassembler 按协议和消息 id 缓存分包, 每个 Codec(即每个连接) 一个, 只在扫描协程中使用
+type reassembler struct {
+	buffers map[string]*fragmentBuffer
+	seq     int
+	now     func() time.Time
+}
+
+func newReassembler(now func() time.Time) *reassembler {
+	return &reassembler{buffers: make(map[string]*fragmentBuffer), now: now}
+}
+
+// evict 协议缓存的消息达到 max_messages 时移除最早的消息, 返回它的错误结果
+func (this *reassembler) evict(p *Protocol) *ScanResult {
+	count := 0
+	var oldestKey string
+	var oldest *fragmentBuffer
+	for key, buffer := range this.buffers {
+		if buffer.protocol != p {
+			continue
+		}
+		count++
+		if oldest == nil || buffer.seq < oldest.seq {
+			oldestKey, oldest = key, buffer
+		}
+	}
+	if count < p.Reassembly.MaxMessages {
+		return nil
+	}
+	delete(this.buffers, oldestKey)
+	return oldest.errorResult(errors.Errorf("Protocol '%s' message '%s': evicted, pending messages exceed max_messages %d, received %d of %d fragments", p.Name, oldest.id, p.Reassembly.MaxMessages, len(oldest.fragments), oldest.total))
+}
+
+// Add 添加解码后的报文, consumed 为 false 表示不是分包, 按普通报文处理
+// 分包未收齐时没有结果, 收齐后返回重组的消息, 出错时返回错误结果, 缓存的消息过多时还会返回被移除的消息的错误结果
+func (this *reassembler) Add(p *Protocol, fields map[string]any) (results []*ScanResult, consumed bool) {
+	result, evicted, consumed := this.add(p, fields)
+	if evicted != nil {
+		results = append(results, evicted)
+	}
+	if result != nil {
+		results = append(results, result)
+	}
+	return results, consumed
+}
+
+func (this *reassembler) add(p *Protocol, fields map[string]any) (result *ScanResult, evicted *ScanResult, consumed bool) {
+	rule := p.Reassembly
+	id, index, total, payload, ok, err := rule.fragment(fields)
+	if err != nil {
+		now := this.now()
+		return &ScanResult{Protocol: p, Data: fields, ScanError: errors.Wrapf(err, "Protocol '%s'", p.Name), Start: &now}, nil, true
+	}
+	if !ok {
+		return nil, nil, false
+	}
+
+	key := p.Name + "\x00" + id
+	buffer, exists := this.buffers[key]
+	if exists && buffer.total != total {
+		delete(this.buffers, key)
+		return buffer.errorResult(errors.Errorf("Protocol '%s' message '%s': total changed from %d to %d", p.Name, id, buffer.total, total)), nil, true
+	}
+	if !exists {
+		evicted = this.evict(p)
+		this.seq++
+		buffer = &fragmentBuffer{protocol: p, id: id, total: total, fragments: make(map[int][]byte), start: this.now(), seq: this.seq}
+		this.buffers[key] = buffer
+	}
+
+	// 重复的分包使用最后收到的
+	buffer.size += len(payload) - len(buffer.fragments[index])
+	buffer.fragments[index] = payload
+	buffer.fields = fields
+	if buffer.size > rule.MaxSize {
+		delete(this.buffers, key)
+		return buffer.errorResult(errors.Errorf("Protocol '%s' message '%s': size %d exceeds max_size %d", p.Name, id, buffer.size, rule.MaxSize)), evicted, true
+	}
+	if len(buffer.fragments) < total {
+		return nil, evicted, true
+	}
+
+	delete(this.buffers, key)
+	message := buffer.join()
+	data, err := rule.decode(p, message, buffer.fields)
+	start, end := buffer.start, this.now()
+	result = &ScanResult{
+		Packet:    message,
+		Protocol:  p,
+		Data:      data,
+		Fragments: total,
+		Start:     &start,
+		End:       &end,
+	}
+	if err != nil {
+		result.ScanError = errors.Wrapf(err, "Protocol '%s' message '%s' decode failed", p.Name, id)
+	}
+	return result, evicted, true
+}
+
+// Expire 移除超时的消息, 返回错误结果
+func (this *reassembler) Expire(now time.Time) []*ScanResult {
+	var results []*ScanResult
+	for _, buffer := range this.remove(func(buffer *fragmentBuffer) bool {
+		return now.Sub(buffer.start) > buffer.protocol.Reassembly.Timeout
+	}) {
+		results = append(results, buffer.errorResult(errors.Errorf("Protocol '%s' message '%s': reassembly timeout after %s, received %d of %d fragments", buffer.protocol.Name, buffer.id, buffer.protocol.Reassembly.Timeout, len(buffer.fragments), buffer.total)))
+	}
+	return results
+}
+
+// Flush 数据流结束, 返回所有未收齐的消息的错误结果
+func (this *reassembler) Flush() []*ScanResult {
+	var results []*ScanResult
+	for _, buffer := range this.remove(func(*fragmentBuffer) bool { return true }) {
+		results = append(results, buffer.errorResult(errors.Errorf("Protocol '%s' message '%s': stream closed, received %d of %d fragments", buffer.protocol.Name, buffer.id, len(buffer.fragments), buffer.total)))
+	}
+	return results
+}
+
+// remove 移除满足条件的消息, 按开始的时间(即创建的顺序)返回, 使结果的顺序不受 map 遍历顺序的影响
+func (this *reassembler) remove(match func(buffer *fragmentBuffer) bool) []*fragmentBuffer {
+	var removed []*fragmentBuffer
+	for key, buffer := range this.buffers {
+		if match(buffer) {
+			delete(this.buffers, key)
+			removed = append(removed, buffer)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].seq < removed[j].seq
+	})
+	return removed
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReassemblerOrder(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &Protocol{Name: "Report", Reassembly: &Reassembly{Timeout: time.Second}}
+	fill := func(r *reassembler) {
+		// 同一时刻开始的消息按创建的顺序返回
+		for i := 0; i < 16; i++ {
+			r.seq++
+			id := fmt.Sprintf("%d", 15-i)
+			r.buffers[id] = &fragmentBuffer{protocol: p, id: id, total: 2, fragments: map[int][]byte{0: {byte(i)}}, start: now, seq: r.seq}
+		}
+	}
+	check := func(name string, results []*ScanResult) {
+		if len(results) != 16 {
+			t.Fatalf("%s: expect 16 results, got %d", name, len(results))
+		}
+		for i, r := range results {
+			if r.Packet[0] != byte(i) {
+				t.Fatalf("%s: expect result %d to be message %d, got %d", name, i, i, r.Packet[0])
+			}
+		}
+	}
+
+	r := newReassembler(func() time.Time { return now })
+	fill(r)
+	if results := r.Expire(now.Add(time.Second)); len(results) != 0 {
+		t.Fatalf("expect no expired message, got %d", len(results))
+	}
+	check("expire", r.Expire(now.Add(2*time.Second)))
+
+	fill(r)
+	check("flush", r.Flush())
+}
@@ -8,8 +8,10 @@ import (
 )
 
 type Scheme struct {
-	Protocols      []*Protocol    `yaml:"protocols"`
-	DataStructures DataStructures `yaml:"data_structures"` // 保持
+	Protocols       []*Protocol    `yaml:"protocols"`
+	TunnelProtocols []*Protocol    `yaml:"tunnel_protocols"` // 只由 tunnel 节点使用的内层协议, 不参与数据流的分包, 也不能直接编码
+	DataStructures  DataStructures `yaml:"data_structures"`  // 保持
+	TrialDecode     bool           `yaml:"trial_decode"`     // 多个协议的报文头标记相同时, 试解码所有分包成功的协议, 使用第一个解码成功的
 }
 
 func NewScheme(content []byte) (*Scheme, error) {
@@ -39,10 +41,41 @@ func NewSchemeFromFile(configFile string) (*Scheme, error) {
 
 func (this *Scheme) Setup() error {
 	for _, protocol := range this.Protocols {
+		protocol.scheme = this
 		err := protocol.Setup(this.DataStructures)
 		if err != nil {
 			return errors.WithStack(err)
 		}
 	}
+	for _, protocol := range this.TunnelProtocols {
+		if this.GetProtocol(protocol.Name) != nil {
+			return errors.Errorf("tunnel protocol '%s' has the same name as a protocol", protocol.Name)
+		}
+		protocol.scheme = this
+		err := protocol.Setup(this.DataStructures)
+		if err != nil {
+			return errors.Wrapf(err, "tunnel protocol '%s'", protocol.Name)
+		}
+	}
 	return nil
 }
+
+// GetProtocol 根据名称获取协议, 不存在时返回 nil
+func (this *Scheme) GetProtocol(name string) *Protocol {
+	for _, protocol := range this.Protocols {
+		if protocol.Name == name {
+			return protocol
+		}
+	}
+	return nil
+}
+
+// GetTunnelProtocol 根据名称获取 tunnel 节点使用的协议, 先查找 tunnel_protocols, 再查找 protocols, 不存在时返回 nil
+func (this *Scheme) GetTunnelProtocol(name string) *Protocol {
+	for _, protocol := range this.TunnelProtocols {
+		if protocol.Name == name {
+			return protocol
+		}
+	}
+	return this.GetProtocol(name)
+}
@@ -0,0 +1,124 @@
+package vpacket
+
+import (
+	"bytes"
+	"testing"
+)
+
+const roundTripScheme = `
+protocols:
+  - name: "Binary"
+    type: "binary"
+    framing_rule:
+      header_marker: "7273"
+      length_offset: 2
+      length_size: 1
+    fields:
+      - name: "magic"
+        size: 2
+      - name: "len"
+        type: "uint"
+        size: 1
+      - name: "body"
+        size_expr: "int(fields.len)"
+  - name: "Text"
+    type: "text"
+    framing_rule:
+      start_delimiter: "s'['"
+      end_delimiter: "s']'"
+      max_len: 128
+    fields:
+      - name: "sn"
+        type: "string"
+        size: -1
+  - name: "Escape"
+    type: "escape"
+    framing_rule:
+      start_delimiter: "7e"
+      end_delimiter: "7e"
+      escape_table:
+        - raw: "7e"
+          escaped: "7d02"
+        - raw: "7d"
+          escaped: "7d01"
+    fields:
+      - name: "body"
+        size: -1
+  - name: "Slip"
+    type: "slip"
+    framing_rule: {}
+    fields:
+      - name: "body"
+        size: -1
+  - name: "Cobs"
+    type: "cobs"
+    framing_rule: {}
+    fields:
+      - name: "body"
+        size: -1
+  - name: "HexText"
+    type: "hex_text"
+    framing_rule:
+      start_delimiter: "s'#'"
+      end_delimiter: "s';'"
+      max_len: 64
+    fields:
+      - name: "body"
+        size: -1
+  - name: "ModbusAscii"
+    type: "modbus_ascii"
+    framing_rule: {}
+    fields:
+      - name: "address"
+        type: "uint"
+        size: 1
+      - name: "body"
+        size: -1
+  - name: "ModbusRtu"
+    type: "modbus_rtu"
+    framing_rule:
+      strip_crc: true
+    fields:
+      - name: "address"
+        type: "uint"
+        size: 1
+      - name: "function"
+        size: 1
+      - name: "body"
+        size: -1
+`
+
+func TestFramingEncodeRoundTrip(t *testing.T) {
+	scheme := mustScheme(t, roundTripScheme)
+
+	frames := map[string][]byte{
+		"Binary":      decodeHex("7273 02 aabb"),
+		"Text":        []byte("[keep_alive]"),
+		"Escape":      decodeHex("7e 01 7d02 02 7d01 7e"),
+		"Slip":        decodeHex("c0 01 dbdc 02 c0"),
+		"Cobs":        decodeHex("00 03 1122 02 33 00"),
+		"HexText":     []byte("#7273AA;"),
+		"ModbusAscii": []byte(":010300000001FB\r\n"),
+		"ModbusRtu":   decodeHex("01 03 0000 000A C5CD"),
+	}
+
+	for _, p := range scheme.Protocols {
+		frame := frames[p.Name]
+		res := p.ParsedFramingRule.Split(frame)
+		if res == nil || res.Advance == 0 || res.Error != nil {
+			t.Fatalf("%s: split failed: %+v", p.Name, res)
+		}
+		fields, err := p.Decode(res.Token)
+		if err != nil {
+			t.Fatalf("%s: decode failed: %+v", p.Name, err)
+		}
+
+		encoded, err := encodeFields(p, fields.(map[string]any))
+		if err != nil {
+			t.Fatalf("%s: encode failed: %+v", p.Name, err)
+		}
+		if !bytes.Equal(encoded, frame) {
+			t.Fatalf("%s: expect %X, got %X", p.Name, frame, encoded)
+		}
+	}
+}
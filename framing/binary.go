@@ -9,24 +9,80 @@ import (
 
 const Binary = "binary"
 
+const (
+	LengthTypeUint   = "uint"   // 定长无符号整数
+	LengthTypeVarint = "varint" // LEB128 变长整数(protobuf, MQTT 等使用)
+)
+
 type BinaryRule struct {
-	HeaderMarker      string `yaml:"header_marker"` // 分隔符,Hex
-	MinHeaderSize     int    `yaml:"min_header_size"`
-	LengthOffset      int    `yaml:"length_offset"`
-	LengthSize        int    `yaml:"length_size"`
-	LengthAdjustment  int    `yaml:"length_adjustment"`
-	headerMarkerBytes []byte
+	HeaderMarker         string              `yaml:"header_marker"` // 分隔符,Hex
+	MinHeaderSize        int                 `yaml:"min_header_size"`
+	LengthOffset         int                 `yaml:"length_offset"`
+	LengthSize           int                 `yaml:"length_size"`            // 长度字段的字节数, uint 支持 1/2/3/4/8, varint 表示最大字节数
+	LengthType           string              `yaml:"length_type"`            // 长度字段类型, uint(默认) 或 varint
+	LengthEndian         string              `yaml:"length_endian"`          // 长度字段字节序, big(默认) 或 little, varint 忽略
+	LengthAdjustment     int                 `yaml:"length_adjustment"`      // 长度修正值, 不包含报文头时为 0 表示报文头在长度字段后结束
+	LengthIncludesHeader bool                `yaml:"length_includes_header"` // 长度值是否已经包含报文头
+	LengthMultiplier     int                 `yaml:"length_multiplier"`      // 长度单位, 如长度为字(word)数时设置为 2, 默认为 1
+	MaxLen               int                 `yaml:"max_len"`                // 报文最大长度, 超过则认为是错误的报文, 默认为 utils.MaxScanTokenSize
+	Checksum             *core.FrameChecksum `yaml:"checksum"`               // 报文校验, 分包时校验失败则丢弃一个字节重新同步
+	headerMarkerBytes    []byte
+	lengthByteOrder      binary.ByteOrder
 }
 
 func (this *BinaryRule) Setup() (err error) {
-	if this.LengthSize == 0 {
-		return errors.New("BinaryRule.Setup: length size should not be zero")
+	if this.LengthType == "" {
+		this.LengthType = LengthTypeUint
+	}
+	switch this.LengthType {
+	case LengthTypeUint:
+		switch this.LengthSize {
+		case 1, 2, 3, 4, 8:
+		case 0:
+			return errors.New("BinaryRule.Setup: length size should not be zero")
+		default:
+			return errors.Errorf("BinaryRule.Setup: unsupported length size %d, should be 1, 2, 3, 4 or 8", this.LengthSize)
+		}
+	case LengthTypeVarint:
+		if this.LengthSize == 0 {
+			this.LengthSize = binary.MaxVarintLen64
+		}
+		if this.LengthSize < 0 || this.LengthSize > binary.MaxVarintLen64 {
+			return errors.Errorf("BinaryRule.Setup: varint length size should between 1 and %d, actual %d", binary.MaxVarintLen64, this.LengthSize)
+		}
+	default:
+		return errors.Errorf("BinaryRule.Setup: unsupported length type '%s', should be uint or varint", this.LengthType)
+	}
+
+	switch this.LengthEndian {
+	case "", "big", "little":
+	default:
+		return errors.Errorf("BinaryRule.Setup: unsupported length endian '%s', should be big or little", this.LengthEndian)
+	}
+	this.lengthByteOrder = utils.GetByteOrder(this.LengthEndian)
+
+	if this.LengthOffset < 0 {
+		return errors.Errorf("BinaryRule.Setup: length offset should not be negative, actual %d", this.LengthOffset)
+	}
+	if this.LengthMultiplier < 0 {
+		return errors.Errorf("BinaryRule.Setup: length multiplier should not be negative, actual %d", this.LengthMultiplier)
 	}
-	if this.LengthAdjustment == 0 {
-		return errors.New("BinaryRule.Setup: length adjustment should not be zero")
+	if this.LengthMultiplier == 0 {
+		this.LengthMultiplier = 1
 	}
+	if this.MaxLen < 0 || this.MaxLen > utils.MaxScanTokenSize {
+		return errors.Errorf("BinaryRule.Setup: max len should between 1 and %d, actual %d", utils.MaxScanTokenSize, this.MaxLen)
+	}
+	if this.MaxLen == 0 {
+		this.MaxLen = utils.MaxScanTokenSize
+	}
+	if err = setupChecksum(this.Checksum, "BinaryRule"); err != nil {
+		return err
+	}
+
 	if this.HeaderMarker == "" {
-		return errors.New("BinaryRule.Setup: no header marker")
+		// 没有报文头标记, 需要通过试解码确认报文
+		return nil
 	}
 	this.headerMarkerBytes, err = utils.ParseTValue(this.HeaderMarker, -1, nil)
 	if err != nil {
@@ -40,20 +96,75 @@ func (this *BinaryRule) Split(data []byte) *core.FramingRuleMatchResult {
 		return core.WaitFramingRuleMatchResult()
 	}
 
-	var bodyLen int
-	if len(data) < this.LengthOffset+this.LengthSize {
+	length, lengthEnd, ok := this.readLength(data)
+	if !ok {
+		// 长度字段不合法, 不可能是正确的报文
+		return core.AbandonFramingRuleMatchResult(1, data)
+	}
+	if lengthEnd < 0 {
 		return core.WaitFramingRuleMatchResult()
 	}
-	lenBytes := data[this.LengthOffset : this.LengthOffset+this.LengthSize]
-	bodyLen = int(binary.BigEndian.Uint16(lenBytes))
 
-	totalLen := this.LengthAdjustment + bodyLen
+	// 先限制长度值的范围, 防止后续计算溢出
+	if length > uint64(utils.MaxScanTokenSize) {
+		return core.AbandonFramingRuleMatchResult(1, data)
+	}
+	totalLen := int(length)*this.LengthMultiplier + this.headerLen(lengthEnd)
+	if totalLen < lengthEnd || totalLen > this.MaxLen {
+		return core.AbandonFramingRuleMatchResult(1, data)
+	}
 
 	if len(data) < totalLen {
-		return nil
+		return core.WaitFramingRuleMatchResult()
 	}
 
-	return core.NewFramingRuleMatchResult(totalLen, data[:totalLen])
+	return verifyChecksum(this.Checksum, core.NewFramingRuleMatchResult(totalLen, data[:totalLen]), data)
+}
+
+// Encode 配置了 checksum 时填充校验值
+func (this *BinaryRule) Encode(payload []byte) ([]byte, error) {
+	return fillChecksum(this.Checksum, payload)
+}
+
+// readLength 读取长度字段, 返回长度值和长度字段的结束位置, 数据不足时结束位置为 -1, 长度字段非法时 ok 为 false
+func (this *BinaryRule) readLength(data []byte) (length uint64, lengthEnd int, ok bool) {
+	if this.LengthType == LengthTypeVarint {
+		end := min(this.LengthOffset+this.LengthSize, len(data))
+		if end <= this.LengthOffset {
+			return 0, -1, true
+		}
+		v, n := binary.Uvarint(data[this.LengthOffset:end])
+		if n < 0 {
+			return 0, 0, false
+		}
+		if n == 0 {
+			if end-this.LengthOffset < this.LengthSize {
+				return 0, -1, true
+			}
+			// 已经读满最大字节数仍未结束
+			return 0, 0, false
+		}
+		return v, this.LengthOffset + n, true
+	}
+
+	lengthEnd = this.LengthOffset + this.LengthSize
+	if len(data) < lengthEnd {
+		return 0, -1, true
+	}
+	v, err := utils.ConvertBytesToInt(data[this.LengthOffset:lengthEnd], this.lengthByteOrder)
+	if err != nil {
+		return 0, 0, false
+	}
+	return v, lengthEnd, true
+}
+
+// headerLen 计算长度值之外需要加上的字节数, 可以为负数
+func (this *BinaryRule) headerLen(lengthEnd int) int {
+	if this.LengthIncludesHeader || this.LengthAdjustment != 0 {
+		return this.LengthAdjustment
+	}
+	// 长度不包含报文头且未设置修正值, 报文头在长度字段之后结束
+	return lengthEnd
 }
 
 func (this *BinaryRule) GetHeaderMarker() []byte {
@@ -0,0 +1,119 @@
+package framing
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	bs, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+func TestBinaryRuleSplit(t *testing.T) {
+	cases := []struct {
+		name    string
+		rule    BinaryRule
+		data    string
+		advance int
+		wait    bool
+	}{
+		{
+			name:    "legacy big endian uint16",
+			rule:    BinaryRule{HeaderMarker: "7273", LengthOffset: 2, LengthSize: 2, LengthAdjustment: 4},
+			data:    "7273 0002 aabb ff",
+			advance: 6,
+		},
+		{
+			name:    "1 byte length, header ends after length",
+			rule:    BinaryRule{HeaderMarker: "68", LengthOffset: 1, LengthSize: 1},
+			data:    "68 03 010203 68",
+			advance: 5,
+		},
+		{
+			name:    "4 byte little endian length",
+			rule:    BinaryRule{HeaderMarker: "68", LengthOffset: 1, LengthSize: 4, LengthEndian: "little"},
+			data:    "68 02000000 0102",
+			advance: 7,
+		},
+		{
+			name:    "length includes header",
+			rule:    BinaryRule{HeaderMarker: "68", LengthOffset: 1, LengthSize: 2, LengthIncludesHeader: true},
+			data:    "68 0005 aabb 00",
+			advance: 5,
+		},
+		{
+			name:    "length includes header with trailing crc",
+			rule:    BinaryRule{HeaderMarker: "68", LengthOffset: 1, LengthSize: 2, LengthIncludesHeader: true, LengthAdjustment: 2},
+			data:    "68 0005 aabb ccdd",
+			advance: 7,
+		},
+		{
+			name:    "word count",
+			rule:    BinaryRule{HeaderMarker: "68", LengthOffset: 1, LengthSize: 1, LengthMultiplier: 2},
+			data:    "68 02 01020304",
+			advance: 6,
+		},
+		{
+			name:    "varint length",
+			rule:    BinaryRule{HeaderMarker: "30", LengthOffset: 1, LengthType: LengthTypeVarint, LengthSize: 4},
+			data:    "30 8001" + strings.Repeat("00", 128),
+			advance: 131,
+		},
+		{
+			name: "incomplete frame",
+			rule: BinaryRule{HeaderMarker: "68", LengthOffset: 1, LengthSize: 1},
+			data: "68 05 0102",
+			wait: true,
+		},
+		{
+			name: "incomplete varint",
+			rule: BinaryRule{HeaderMarker: "30", LengthOffset: 1, LengthType: LengthTypeVarint, LengthSize: 4},
+			data: "30 80",
+			wait: true,
+		},
+		{
+			name:    "length too large",
+			rule:    BinaryRule{HeaderMarker: "68", LengthOffset: 1, LengthSize: 1, MaxLen: 8},
+			data:    "68 10 0102",
+			advance: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.rule.Setup(); err != nil {
+				t.Fatalf("setup: %+v", err)
+			}
+			res := c.rule.Split(mustHex(c.data))
+			if c.wait {
+				if res.Advance != 0 || res.Abandoned {
+					t.Fatalf("expect wait, got advance %d, abandoned %v", res.Advance, res.Abandoned)
+				}
+				return
+			}
+			if res.Advance != c.advance {
+				t.Fatalf("expect advance %d, got %d", c.advance, res.Advance)
+			}
+		})
+	}
+}
+
+func TestBinaryRuleSetup(t *testing.T) {
+	invalid := []BinaryRule{
+		{HeaderMarker: "68", LengthSize: 5},
+		{HeaderMarker: "68", LengthSize: 2, LengthEndian: "middle"},
+		{HeaderMarker: "68", LengthSize: 2, LengthType: "bcd"},
+		{HeaderMarker: "68", LengthType: LengthTypeVarint, LengthSize: 11},
+		{HeaderMarker: "68", LengthSize: 2, LengthMultiplier: -1},
+	}
+	for _, rule := range invalid {
+		if err := rule.Setup(); err == nil {
+			t.Fatalf("expect setup error for %+v", rule)
+		}
+	}
+}
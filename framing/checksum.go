@@ -0,0 +1,37 @@
+package framing
+
+import (
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+)
+
+// setupChecksum 未配置校验时返回 nil
+func setupChecksum(checksum *core.FrameChecksum, rule string) error {
+	if checksum == nil {
+		return nil
+	}
+	return errors.Wrapf(checksum.Setup(), "%s.Setup: invalid checksum", rule)
+}
+
+// verifyChecksum 校验分包结果, 校验失败时丢弃一个字节重新同步, 防止错误的报文头导致后续正确的报文被吞掉
+func verifyChecksum(checksum *core.FrameChecksum, res *core.FramingRuleMatchResult, data []byte) *core.FramingRuleMatchResult {
+	if checksum == nil || res == nil || res.Advance == 0 || res.Abandoned || res.Error != nil {
+		return res
+	}
+	if err := checksum.Verify(res.Token); err != nil {
+		return core.AbandonFramingRuleMatchResult(1, data)
+	}
+	return res
+}
+
+// fillChecksum 编码时填充校验值, 不修改传入的数据
+func fillChecksum(checksum *core.FrameChecksum, payload []byte) ([]byte, error) {
+	if checksum == nil {
+		return payload, nil
+	}
+	ret := append([]byte(nil), payload...)
+	if err := checksum.Fill(ret); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return ret, nil
+}
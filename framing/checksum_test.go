@@ -0,0 +1,94 @@
+package framing
+
+import (
+	"bytes"
+	"github.com/vuuvv/vpacket/core"
+	"testing"
+)
+
+func withSum8(s string) []byte {
+	frame := mustHex(s)
+	return append(frame, core.Sum8(frame))
+}
+
+func TestBinaryRuleChecksum(t *testing.T) {
+	rule := BinaryRule{
+		HeaderMarker: "68", LengthOffset: 1, LengthSize: 1, LengthAdjustment: 3,
+		Checksum: &core.FrameChecksum{Algorithm: core.KEY_SUM8},
+	}
+	if err := rule.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	frame := withSum8("68 02 aabb")
+	res := rule.Split(frame)
+	if res.Advance != len(frame) || res.Abandoned {
+		t.Fatalf("expect frame of %d bytes, got advance %d, abandoned %v", len(frame), res.Advance, res.Abandoned)
+	}
+
+	corrupted := append([]byte(nil), frame...)
+	corrupted[2] ^= 0xff
+	res = rule.Split(corrupted)
+	if res.Advance != 1 || !res.Abandoned {
+		t.Fatalf("expect abandon 1 byte, got advance %d, abandoned %v", res.Advance, res.Abandoned)
+	}
+
+	encoded, err := rule.Encode(mustHex("68 02 aabb 00"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(encoded, frame) {
+		t.Fatalf("expect %X, got %X", frame, encoded)
+	}
+}
+
+func TestFrameChecksumRange(t *testing.T) {
+	// 7e 头不参与校验, crc 在结束标志之前
+	start, end, offset := 1, -3, -3
+	checksum := &core.FrameChecksum{Algorithm: "crc16_modbus", Start: start, End: &end, Offset: &offset, Endian: "little"}
+	if err := checksum.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	frame := mustHex("7e 0103 0000 000a 0000 7e")
+	if err := checksum.Fill(frame); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(frame[7:9], mustHex("c5cd")) {
+		t.Fatalf("expect crc c5cd, got %X", frame[7:9])
+	}
+	if err := checksum.Verify(frame); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := checksum.Verify(frame[:2]); err == nil {
+		t.Fatal("expect error for short frame")
+	}
+}
+
+func TestEscapeRuleChecksumResync(t *testing.T) {
+	rule := EscapeRule{
+		StartDelimiter: "7e", EndDelimiter: "7e",
+		EscapeTable: []*EscapeSequence{{Raw: "7e", Escaped: "7d02"}, {Raw: "7d", Escaped: "7d01"}},
+		Checksum:    &core.FrameChecksum{Algorithm: core.KEY_XOR8},
+	}
+	if err := rule.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	payload := mustHex("0102 7e")
+	payload = append(payload, core.Xor8(payload))
+	frame, err := rule.Encode(append(payload[:len(payload)-1:len(payload)-1], 0))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	res := rule.Split(frame)
+	if res.Advance != len(frame) || !bytes.Equal(res.Token, payload) {
+		t.Fatalf("expect token %X, got advance %d token %X", payload, res.Advance, res.Token)
+	}
+
+	frame[1] = 0x09
+	res = rule.Split(frame)
+	if res.Advance != 1 || !res.Abandoned {
+		t.Fatalf("expect abandon 1 byte, got advance %d, abandoned %v", res.Advance, res.Abandoned)
+	}
+}
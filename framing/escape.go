@@ -0,0 +1,308 @@
+package framing
+
+import (
+	"bytes"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+	"github.com/vuuvv/vpacket/utils"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+)
+
+const (
+	Escape = "escape"
+	Slip   = "slip"
+	Cobs   = "cobs"
+)
+
+const (
+	EscapeEncodingTable = "table" // 按转义表进行转义, 如 HDLC, JT/T 808, SLIP
+	EscapeEncodingCobs  = "cobs"  // Consistent Overhead Byte Stuffing
+)
+
+type EscapeSequence struct {
+	Raw          string `yaml:"raw"`     // 原始字节, Hex
+	Escaped      string `yaml:"escaped"` // 转义后的字节, Hex
+	rawBytes     []byte
+	escapedBytes []byte
+}
+
+// EscapeRule 以起始/结束标志分隔报文, 报文内部的标志字节经过转义
+// 分包时返回反转义后的报文, 编码时对报文进行转义并添加标志, 因此 fields 中的偏移和 CRC 都是针对反转义后的数据
+type EscapeRule struct {
+	StartDelimiter      string              `yaml:"start_delimiter"` // 起始标志, 为空时协议没有报文头标记, 需要通过试解码确认报文, slip/cobs 只在没有配置时使用默认值
+	EndDelimiter        string              `yaml:"end_delimiter"`
+	Encoding            string              `yaml:"encoding"`          // 转义方式, table(默认) 或 cobs
+	EscapeTable         []*EscapeSequence   `yaml:"escape_table"`      // 转义表
+	ContainDelimiter    bool                `yaml:"contain_delimiter"` // 返回的token是否包含分隔符
+	ShareDelimiter      bool                `yaml:"share_delimiter"`   // 起始和结束标志相同时, 前一帧的结束标志可以作为下一帧的起始标志
+	MaxLen              int                 `yaml:"max_len"`
+	Checksum            *core.FrameChecksum `yaml:"checksum"` // 报文校验, 针对反转义后的token, 分包时校验失败则丢弃一个字节重新同步
+	startDelimiterBytes []byte
+	endDelimiterBytes   []byte
+	escapeBytes         [256]bool // 转义序列的首字节
+}
+
+func (this *EscapeRule) Setup() (err error) {
+	if this.EndDelimiter == "" {
+		return errors.New("EscapeRule.Setup: end_delimiter should not be empty")
+	}
+	this.startDelimiterBytes, err = utils.ParseTValue(this.StartDelimiter, -1, nil)
+	if err != nil {
+		return errors.Wrapf(err, "EscapeRule.Setup: invalid start_delimiter: %s", this.StartDelimiter)
+	}
+	this.endDelimiterBytes, err = utils.ParseTValue(this.EndDelimiter, -1, nil)
+	if err != nil {
+		return errors.Wrapf(err, "EscapeRule.Setup: invalid end_delimiter: %s", this.EndDelimiter)
+	}
+	if this.ShareDelimiter && !bytes.Equal(this.startDelimiterBytes, this.endDelimiterBytes) {
+		return errors.New("EscapeRule.Setup: share_delimiter requires start_delimiter and end_delimiter to be the same")
+	}
+
+	if this.MaxLen < 0 || this.MaxLen > utils.MaxScanTokenSize {
+		return errors.Errorf("EscapeRule.Setup: max len should between 1 and %d, actual %d", utils.MaxScanTokenSize, this.MaxLen)
+	}
+	if this.MaxLen == 0 {
+		this.MaxLen = utils.MaxScanTokenSize
+	}
+	if err = setupChecksum(this.Checksum, "EscapeRule"); err != nil {
+		return err
+	}
+
+	if this.Encoding == "" {
+		this.Encoding = EscapeEncodingTable
+	}
+	switch this.Encoding {
+	case EscapeEncodingTable:
+		if len(this.EscapeTable) == 0 {
+			return errors.New("EscapeRule.Setup: escape_table should not be empty")
+		}
+		for _, seq := range this.EscapeTable {
+			seq.rawBytes, err = utils.ParseTValue(seq.Raw, -1, nil)
+			if err != nil {
+				return errors.Wrapf(err, "EscapeRule.Setup: invalid escape raw: %s", seq.Raw)
+			}
+			seq.escapedBytes, err = utils.ParseTValue(seq.Escaped, -1, nil)
+			if err != nil {
+				return errors.Wrapf(err, "EscapeRule.Setup: invalid escape sequence: %s", seq.Escaped)
+			}
+			if len(seq.rawBytes) == 0 || len(seq.escapedBytes) == 0 {
+				return errors.Errorf("EscapeRule.Setup: escape raw and escaped should not be empty: %s -> %s", seq.Raw, seq.Escaped)
+			}
+			this.escapeBytes[seq.escapedBytes[0]] = true
+		}
+		// 长的序列优先匹配
+		sort.SliceStable(this.EscapeTable, func(i, j int) bool {
+			return len(this.EscapeTable[i].escapedBytes) > len(this.EscapeTable[j].escapedBytes)
+		})
+	case EscapeEncodingCobs:
+		if !bytes.Equal(this.endDelimiterBytes, []byte{0}) {
+			return errors.New("EscapeRule.Setup: cobs encoding requires end_delimiter to be 00")
+		}
+	default:
+		return errors.Errorf("EscapeRule.Setup: unsupported encoding '%s', should be table or cobs", this.Encoding)
+	}
+	return nil
+}
+
+func (this *EscapeRule) Split(data []byte) *core.FramingRuleMatchResult {
+	return verifyChecksum(this.Checksum, this.split(data), data)
+}
+
+func (this *EscapeRule) split(data []byte) *core.FramingRuleMatchResult {
+	startLen := len(this.startDelimiterBytes)
+	if len(data) < startLen {
+		return core.WaitFramingRuleMatchResult()
+	}
+
+	idx := bytes.Index(data[startLen:], this.endDelimiterBytes)
+	if idx < 0 {
+		if len(data) > this.MaxLen {
+			return core.AbandonFramingRuleMatchResult(1, data)
+		}
+		return core.WaitFramingRuleMatchResult()
+	}
+	if idx == 0 {
+		// 连续的标志, 空帧, 丢弃起始标志, 没有起始标志时丢弃结束标志
+		if startLen == 0 {
+			return core.AbandonFramingRuleMatchResult(len(this.endDelimiterBytes), data)
+		}
+		return core.AbandonFramingRuleMatchResult(startLen, data)
+	}
+
+	bodyEnd := startLen + idx
+	advance := bodyEnd + len(this.endDelimiterBytes)
+	if this.ShareDelimiter {
+		// 结束标志留给下一帧作为起始标志
+		advance = bodyEnd
+	}
+
+	payload, err := this.unescape(data[startLen:bodyEnd])
+	if err != nil {
+		return core.InvalidFramingRuleMatchResult(advance, data[:advance], err)
+	}
+
+	if this.ContainDelimiter {
+		token := make([]byte, 0, startLen+len(payload)+len(this.endDelimiterBytes))
+		token = append(token, this.startDelimiterBytes...)
+		token = append(token, payload...)
+		token = append(token, this.endDelimiterBytes...)
+		return core.NewFramingRuleMatchResult(advance, token)
+	}
+	return core.NewFramingRuleMatchResult(advance, payload)
+}
+
+func (this *EscapeRule) GetHeaderMarker() []byte {
+	return this.startDelimiterBytes
+}
+
+// Encode 对报文进行转义并添加起始/结束标志
+func (this *EscapeRule) Encode(payload []byte) ([]byte, error) {
+	payload, err := fillChecksum(this.Checksum, payload)
+	if err != nil {
+		return nil, err
+	}
+	if this.ContainDelimiter {
+		payload = bytes.TrimPrefix(payload, this.startDelimiterBytes)
+		payload = bytes.TrimSuffix(payload, this.endDelimiterBytes)
+	}
+	escaped := this.escape(payload)
+	ret := make([]byte, 0, len(this.startDelimiterBytes)+len(escaped)+len(this.endDelimiterBytes))
+	ret = append(ret, this.startDelimiterBytes...)
+	ret = append(ret, escaped...)
+	ret = append(ret, this.endDelimiterBytes...)
+	return ret, nil
+}
+
+func (this *EscapeRule) unescape(data []byte) ([]byte, error) {
+	if this.Encoding == EscapeEncodingCobs {
+		return utils.CobsDecode(data)
+	}
+
+	ret := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		if !this.escapeBytes[data[i]] {
+			ret = append(ret, data[i])
+			i++
+			continue
+		}
+		matched := false
+		for _, seq := range this.EscapeTable {
+			if bytes.HasPrefix(data[i:], seq.escapedBytes) {
+				ret = append(ret, seq.rawBytes...)
+				i += len(seq.escapedBytes)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, errors.Errorf("invalid escape sequence at offset %d: %X", i, data[i:min(i+2, len(data))])
+		}
+	}
+	return ret, nil
+}
+
+func (this *EscapeRule) escape(data []byte) []byte {
+	if this.Encoding == EscapeEncodingCobs {
+		return utils.CobsEncode(data)
+	}
+
+	ret := make([]byte, 0, len(data)+len(data)/8)
+	for i := 0; i < len(data); {
+		matched := false
+		for _, seq := range this.EscapeTable {
+			if bytes.HasPrefix(data[i:], seq.rawBytes) {
+				ret = append(ret, seq.escapedBytes...)
+				i += len(seq.rawBytes)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			ret = append(ret, data[i])
+			i++
+		}
+	}
+	return ret
+}
+
+// SlipRule SLIP(RFC 1055) 分包规则, 默认使用 C0 作为帧标志, DB DC/DB DD 作为转义
+type SlipRule struct {
+	EscapeRule        `yaml:",inline"`
+	startDelimiterSet bool // 配置了 start_delimiter, 为空时表示没有起始标志, 不使用默认值
+}
+
+func (this *SlipRule) UnmarshalYAML(value *yaml.Node) (err error) {
+	if err = value.Decode(&this.EscapeRule); err != nil {
+		return errors.WithStack(err)
+	}
+	this.startDelimiterSet, err = hasStartDelimiter(value)
+	return err
+}
+
+func (this *SlipRule) Setup() error {
+	if this.StartDelimiter == "" && !this.startDelimiterSet {
+		this.StartDelimiter = "c0"
+	}
+	if this.EndDelimiter == "" {
+		this.EndDelimiter = "c0"
+	}
+	if len(this.EscapeTable) == 0 {
+		this.EscapeTable = []*EscapeSequence{
+			{Raw: "c0", Escaped: "dbdc"},
+			{Raw: "db", Escaped: "dbdd"},
+		}
+	}
+	if strings.EqualFold(this.StartDelimiter, this.EndDelimiter) {
+		this.ShareDelimiter = true
+	}
+	this.Encoding = EscapeEncodingTable
+	return this.EscapeRule.Setup()
+}
+
+// CobsRule COBS 分包规则, 使用 00 作为帧标志
+// 没有配置 start_delimiter 时默认为 00, 配置为空字符串时没有起始标志, 需要通过试解码确认报文
+type CobsRule struct {
+	EscapeRule        `yaml:",inline"`
+	startDelimiterSet bool // 配置了 start_delimiter, 为空时表示没有起始标志, 不使用默认值
+}
+
+func (this *CobsRule) UnmarshalYAML(value *yaml.Node) (err error) {
+	if err = value.Decode(&this.EscapeRule); err != nil {
+		return errors.WithStack(err)
+	}
+	this.startDelimiterSet, err = hasStartDelimiter(value)
+	return err
+}
+
+func (this *CobsRule) Setup() error {
+	if this.StartDelimiter == "" && !this.startDelimiterSet {
+		this.StartDelimiter = "00"
+	}
+	if this.EndDelimiter == "" {
+		this.EndDelimiter = "00"
+	}
+	if strings.EqualFold(this.StartDelimiter, this.EndDelimiter) {
+		this.ShareDelimiter = true
+	}
+	this.Encoding = EscapeEncodingCobs
+	return this.EscapeRule.Setup()
+}
+
+// hasStartDelimiter 配置中是否有 start_delimiter, 用于区分没有配置(使用默认值)和配置为空(没有起始标志)
+func hasStartDelimiter(value *yaml.Node) (bool, error) {
+	var keys struct {
+		StartDelimiter *string `yaml:"start_delimiter"`
+	}
+	if err := value.Decode(&keys); err != nil {
+		return false, errors.WithStack(err)
+	}
+	return keys.StartDelimiter != nil, nil
+}
+
+func registerEscape() {
+	core.RegisterFramingRuleDecoderFactory[EscapeRule](Escape)
+	core.RegisterFramingRuleDecoderFactory[SlipRule](Slip)
+	core.RegisterFramingRuleDecoderFactory[CobsRule](Cobs)
+}
@@ -0,0 +1,123 @@
+package framing
+
+import (
+	"bytes"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestEscapeRuleJT808(t *testing.T) {
+	rule := &EscapeRule{
+		StartDelimiter: "7e",
+		EndDelimiter:   "7e",
+		EscapeTable: []*EscapeSequence{
+			{Raw: "7e", Escaped: "7d02"},
+			{Raw: "7d", Escaped: "7d01"},
+		},
+	}
+	if err := rule.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	frame := mustHex("7e 0102 7d02 7d01 03 7e 7e")
+	res := rule.Split(frame)
+	if res.Advance != 9 {
+		t.Fatalf("expect advance 9, got %d", res.Advance)
+	}
+	if !bytes.Equal(res.Token, mustHex("0102 7e 7d 03")) {
+		t.Fatalf("unexpected token %X", res.Token)
+	}
+
+	encoded, err := rule.Encode(res.Token)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(encoded, frame[:9]) {
+		t.Fatalf("unexpected encoded %X", encoded)
+	}
+
+	res = rule.Split(mustHex("7e 01 7d05 7e"))
+	if res.Error == nil || res.Advance != 5 {
+		t.Fatalf("expect consumed invalid frame, got advance %d, err %v", res.Advance, res.Error)
+	}
+
+	res = rule.Split(mustHex("7e 0102"))
+	if res.Advance != 0 || res.Abandoned {
+		t.Fatalf("expect wait, got advance %d", res.Advance)
+	}
+}
+
+func TestSlipRule(t *testing.T) {
+	rule := &SlipRule{}
+	if err := rule.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	payload := mustHex("01 c0 02 db 03")
+	encoded, err := rule.Encode(payload)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(encoded, mustHex("c0 01 dbdc 02 dbdd 03 c0")) {
+		t.Fatalf("unexpected encoded %X", encoded)
+	}
+	res := rule.Split(encoded)
+	if !bytes.Equal(res.Token, payload) {
+		t.Fatalf("unexpected token %X", res.Token)
+	}
+	// 结束标志保留给下一帧
+	if res.Advance != len(encoded)-1 {
+		t.Fatalf("unexpected advance %d", res.Advance)
+	}
+}
+
+func TestCobsRule(t *testing.T) {
+	rule := &CobsRule{}
+	if err := rule.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	long := bytes.Repeat([]byte{0x11}, 300)
+	for _, payload := range [][]byte{mustHex("00"), mustHex("11 22 00 33"), mustHex("11 00 00"), long} {
+		encoded, err := rule.Encode(payload)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if bytes.IndexByte(encoded[1:len(encoded)-1], 0) >= 0 {
+			t.Fatalf("encoded data contains delimiter: %X", encoded)
+		}
+		res := rule.Split(encoded)
+		if !bytes.Equal(res.Token, payload) {
+			t.Fatalf("expect %X, got %X", payload, res.Token)
+		}
+	}
+}
+
+func TestCobsStartDelimiter(t *testing.T) {
+	cases := []struct {
+		config string
+		marker []byte
+	}{
+		{`end_delimiter: "00"`, mustHex("00")},
+		// 显式配置为空时没有起始标志, 不使用默认值
+		{`start_delimiter: ""`, nil},
+		{`start_delimiter: "7e"`, mustHex("7e")},
+	}
+	for _, c := range cases {
+		var rule CobsRule
+		if err := yaml.Unmarshal([]byte(c.config), &rule); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if err := rule.Setup(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if !bytes.Equal(rule.GetHeaderMarker(), c.marker) {
+			t.Fatalf("%s: expect marker %X, got %X", c.config, c.marker, rule.GetHeaderMarker())
+		}
+		encoded, err := rule.Encode(mustHex("11 00 22"))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if res := rule.Split(encoded); !bytes.Equal(res.Token, mustHex("11 00 22")) {
+			t.Fatalf("%s: unexpected token %X of %X", c.config, res.Token, encoded)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package framing
+
+import (
+	"encoding/hex"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+	"strings"
+)
+
+const (
+	HexText     = "hex_text"
+	ModbusAscii = "modbus_ascii"
+)
+
+// HexTextRule 报文以文本分隔符分隔, 内容为十六进制字符, 分包时转换为字节, 编码时转换为十六进制字符并添加分隔符
+// checksum 针对转换后的字节(不包含 LRC)
+type HexTextRule struct {
+	TextRule `yaml:",inline"`
+	Lrc      bool `yaml:"lrc"` // 报文末尾是否有 LRC 校验字节, 分包时校验并去掉(校验失败时丢弃一个字节), 编码时自动添加
+}
+
+func (this *HexTextRule) Setup() error {
+	if this.ContainDelimiter {
+		return errors.New("HexTextRule.Setup: contain_delimiter is not supported")
+	}
+	return this.TextRule.Setup()
+}
+
+func (this *HexTextRule) Split(data []byte) *core.FramingRuleMatchResult {
+	res := this.TextRule.split(data)
+	if res == nil || res.Advance == 0 || res.Abandoned || res.Error != nil {
+		return res
+	}
+
+	// 不是合法的 hex 或 LRC 校验失败时和 checksum 一样丢弃一个字节重新同步, 避免吞掉从报文中间开始的合法报文
+	payload, err := hex.DecodeString(string(res.Token))
+	if err != nil {
+		return core.AbandonFramingRuleMatchResult(1, data)
+	}
+
+	if this.Lrc {
+		if len(payload) < 1 {
+			return core.AbandonFramingRuleMatchResult(1, data)
+		}
+		n := len(payload) - 1
+		if core.Lrc(payload[:n]) != payload[n] {
+			return core.AbandonFramingRuleMatchResult(1, data)
+		}
+		payload = payload[:n]
+	}
+
+	res.Token = payload
+	return verifyChecksum(this.Checksum, res, data)
+}
+
+// Encode 将报文转换为十六进制字符, 并添加 LRC 和分隔符
+func (this *HexTextRule) Encode(payload []byte) ([]byte, error) {
+	payload, err := fillChecksum(this.Checksum, payload)
+	if err != nil {
+		return nil, err
+	}
+	if this.Lrc {
+		payload = append(payload[:len(payload):len(payload)], core.Lrc(payload))
+	}
+	content := strings.ToUpper(hex.EncodeToString(payload))
+
+	ret := make([]byte, 0, len(this.startDelimiterBytes)+len(content)+len(this.endDelimiterBytes))
+	ret = append(ret, this.startDelimiterBytes...)
+	ret = append(ret, content...)
+	ret = append(ret, this.endDelimiterBytes...)
+	return ret, nil
+}
+
+// ModbusAsciiRule Modbus ASCII 分包规则, 报文格式为 ':' + 十六进制字符 + LRC + CRLF
+type ModbusAsciiRule struct {
+	HexTextRule `yaml:",inline"`
+}
+
+func (this *ModbusAsciiRule) Setup() error {
+	if this.StartDelimiter == "" {
+		this.StartDelimiter = "s':'"
+	}
+	if this.EndDelimiter == "" {
+		this.EndDelimiter = "0d0a"
+	}
+	if this.MaxLen == 0 {
+		this.MaxLen = 513 // Modbus ASCII ADU 最大字符数
+	}
+	this.Lrc = true
+	return this.HexTextRule.Setup()
+}
+
+func registerHexText() {
+	core.RegisterFramingRuleDecoderFactory[HexTextRule](HexText)
+	core.RegisterFramingRuleDecoderFactory[ModbusAsciiRule](ModbusAscii)
+}
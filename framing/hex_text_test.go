@@ -0,0 +1,64 @@
+package framing
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestModbusAsciiRule(t *testing.T) {
+	rule := &ModbusAsciiRule{}
+	if err := rule.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	frame := []byte(":010300000001FB\r\n")
+	res := rule.Split(append(frame, ':'))
+	if res.Error != nil || res.Advance != len(frame) {
+		t.Fatalf("unexpected result advance %d, err %v", res.Advance, res.Error)
+	}
+	if !bytes.Equal(res.Token, mustHex("01 03 0000 0001")) {
+		t.Fatalf("unexpected token %X", res.Token)
+	}
+
+	encoded, err := rule.Encode(res.Token)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(encoded, frame) {
+		t.Fatalf("unexpected encoded %q", encoded)
+	}
+
+	res = rule.Split([]byte(":010300000001FC\r\n"))
+	if !res.Abandoned || res.Advance != 1 {
+		t.Fatalf("expect 1 abandoned byte on LRC error, got advance %d, err %v", res.Advance, res.Error)
+	}
+
+	// 损坏的报文中间开始的合法报文不会被吞掉
+	data := append([]byte(":0103"), frame...)
+	for res = rule.Split(data); res.Abandoned; res = rule.Split(data) {
+		data = data[res.Advance:]
+	}
+	if res.Error != nil || !bytes.Equal(res.Token, mustHex("01 03 0000 0001")) {
+		t.Fatalf("expect resync to the valid frame, got %X, err %v", res.Token, res.Error)
+	}
+
+	res = rule.Split([]byte(":0103"))
+	if res.Advance != 0 || res.Error != nil {
+		t.Fatalf("expect wait, got advance %d", res.Advance)
+	}
+}
+
+func TestHexTextRule(t *testing.T) {
+	rule := &HexTextRule{TextRule: TextRule{StartDelimiter: "s'#'", EndDelimiter: "s';'", MaxLen: 64}}
+	if err := rule.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	res := rule.Split([]byte("#7273aa01;"))
+	if !bytes.Equal(res.Token, mustHex("7273aa01")) {
+		t.Fatalf("unexpected token %X", res.Token)
+	}
+	res = rule.Split([]byte("#72zz;"))
+	if !res.Abandoned || res.Advance != 1 {
+		t.Fatalf("expect 1 abandoned byte on invalid hex, got advance %d", res.Advance)
+	}
+}
@@ -0,0 +1,178 @@
+package framing
+
+import (
+	"encoding/binary"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+	"github.com/vuuvv/vpacket/crc16"
+)
+
+const ModbusRtu = "modbus_rtu"
+
+const (
+	ModbusDirectionRequest  = "request"  // 只识别请求报文(主站发送)
+	ModbusDirectionResponse = "response" // 只识别响应报文(从站发送)
+
+	modbusRtuMaxLen = 256 // RTU ADU 最大长度
+	modbusRtuMinLen = 4   // 地址 + 功能码 + CRC
+	modbusCrcSize   = 2
+)
+
+// modbusLayout 根据已接收的数据计算报文长度, 数据不足以计算时返回 -1
+type modbusLayout func(data []byte) int
+
+// modbusFixed 定长报文
+func modbusFixed(size int) modbusLayout {
+	return func(data []byte) int {
+		return size
+	}
+}
+
+// modbusCount8 在 at 位置有一个字节的字节数, 后面跟着数据和 CRC
+func modbusCount8(at int) modbusLayout {
+	return func(data []byte) int {
+		if len(data) <= at {
+			return -1
+		}
+		return at + 1 + int(data[at]) + modbusCrcSize
+	}
+}
+
+// modbusCount16 在 at 位置有两个字节(大端)的字节数, 后面跟着数据和 CRC
+func modbusCount16(at int) modbusLayout {
+	return func(data []byte) int {
+		if len(data) < at+2 {
+			return -1
+		}
+		return at + 2 + int(binary.BigEndian.Uint16(data[at:at+2])) + modbusCrcSize
+	}
+}
+
+// modbusLayouts 功能码对应的请求和响应报文格式
+var modbusLayouts = map[byte][2]modbusLayout{
+	0x01: {modbusFixed(8), modbusCount8(2)},   // 读线圈
+	0x02: {modbusFixed(8), modbusCount8(2)},   // 读离散输入
+	0x03: {modbusFixed(8), modbusCount8(2)},   // 读保持寄存器
+	0x04: {modbusFixed(8), modbusCount8(2)},   // 读输入寄存器
+	0x05: {modbusFixed(8), modbusFixed(8)},    // 写单个线圈
+	0x06: {modbusFixed(8), modbusFixed(8)},    // 写单个寄存器
+	0x07: {modbusFixed(4), modbusFixed(5)},    // 读异常状态
+	0x08: {modbusFixed(8), modbusFixed(8)},    // 诊断
+	0x0B: {modbusFixed(4), modbusFixed(8)},    // 获取通信事件计数器
+	0x0C: {modbusFixed(4), modbusCount8(2)},   // 获取通信事件记录
+	0x0F: {modbusCount8(6), modbusFixed(8)},   // 写多个线圈
+	0x10: {modbusCount8(6), modbusFixed(8)},   // 写多个寄存器
+	0x11: {modbusFixed(4), modbusCount8(2)},   // 报告从站ID
+	0x14: {modbusCount8(2), modbusCount8(2)},  // 读文件记录
+	0x15: {modbusCount8(2), modbusCount8(2)},  // 写文件记录
+	0x16: {modbusFixed(10), modbusFixed(10)},  // 屏蔽写寄存器
+	0x17: {modbusCount8(10), modbusCount8(2)}, // 读写多个寄存器
+	0x18: {modbusFixed(6), modbusCount16(2)},  // 读FIFO队列
+}
+
+// ModbusRtuRule Modbus RTU 分包规则
+// 报文没有报文头标记, 根据功能码计算报文长度, 并使用 CRC-16/MODBUS 确认报文边界, CRC 错误时丢弃一个字节重新同步
+type ModbusRtuRule struct {
+	Direction string `yaml:"direction"` // request 或 response, 为空时两者都尝试
+	StripCrc  bool   `yaml:"strip_crc"` // 返回的token是否去掉末尾的CRC
+}
+
+func (this *ModbusRtuRule) Setup() error {
+	switch this.Direction {
+	case "", ModbusDirectionRequest, ModbusDirectionResponse:
+	default:
+		return errors.Errorf("ModbusRtuRule.Setup: unsupported direction '%s', should be request or response", this.Direction)
+	}
+	return nil
+}
+
+func (this *ModbusRtuRule) Split(data []byte) *core.FramingRuleMatchResult {
+	if len(data) < 2 {
+		return core.WaitFramingRuleMatchResult()
+	}
+	// 0 为广播地址, 248~255 为保留地址
+	if data[0] > 247 {
+		return core.AbandonFramingRuleMatchResult(1, data)
+	}
+
+	layouts := this.layouts(data[1])
+	if len(layouts) == 0 {
+		return core.AbandonFramingRuleMatchResult(1, data)
+	}
+
+	waiting := false
+	for _, layout := range layouts {
+		size := layout(data)
+		if size < 0 {
+			waiting = true
+			continue
+		}
+		if size < modbusRtuMinLen || size > modbusRtuMaxLen {
+			continue
+		}
+		if len(data) < size {
+			waiting = true
+			continue
+		}
+		if !modbusCrcValid(data[:size]) {
+			continue
+		}
+		if this.StripCrc {
+			return core.NewFramingRuleMatchResult(size, data[:size-modbusCrcSize])
+		}
+		return core.NewFramingRuleMatchResult(size, data[:size])
+	}
+
+	if waiting {
+		return core.WaitFramingRuleMatchResult()
+	}
+	// CRC 校验失败, 丢弃一个字节重新同步
+	return core.AbandonFramingRuleMatchResult(1, data)
+}
+
+// layouts 根据功能码和方向获取可能的报文格式
+func (this *ModbusRtuRule) layouts(function byte) []modbusLayout {
+	if function&0x80 != 0 {
+		// 异常响应: 地址 + 功能码 + 异常码 + CRC
+		if this.Direction == ModbusDirectionRequest {
+			return nil
+		}
+		return []modbusLayout{modbusFixed(5)}
+	}
+
+	layouts, ok := modbusLayouts[function]
+	if !ok {
+		return nil
+	}
+	switch this.Direction {
+	case ModbusDirectionRequest:
+		return layouts[:1]
+	case ModbusDirectionResponse:
+		return layouts[1:]
+	}
+	return layouts[:]
+}
+
+// Encode strip_crc 时 fields 中不包含 CRC, 在报文末尾添加 CRC
+func (this *ModbusRtuRule) Encode(payload []byte) ([]byte, error) {
+	if !this.StripCrc {
+		return payload, nil
+	}
+	crc := crc16.Checksum(payload, crc16.MODBUS)
+	return binary.LittleEndian.AppendUint16(payload[:len(payload):len(payload)], crc), nil
+}
+
+func (this *ModbusRtuRule) GetHeaderMarker() []byte {
+	return nil
+}
+
+// modbusCrcValid 校验报文末尾的CRC, CRC为小端
+func modbusCrcValid(frame []byte) bool {
+	n := len(frame) - modbusCrcSize
+	crc := crc16.Checksum(frame[:n], crc16.MODBUS)
+	return binary.LittleEndian.Uint16(frame[n:]) == crc
+}
+
+func registerModbus() {
+	core.RegisterFramingRuleDecoderFactory[ModbusRtuRule](ModbusRtu)
+}
@@ -0,0 +1,56 @@
+package framing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/vuuvv/vpacket/crc16"
+	"testing"
+)
+
+func withModbusCrc(s string) []byte {
+	frame := mustHex(s)
+	return binary.LittleEndian.AppendUint16(frame, crc16.Checksum(frame, crc16.MODBUS))
+}
+
+func TestModbusRtuRule(t *testing.T) {
+	rule := &ModbusRtuRule{}
+	if err := rule.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	request := mustHex("01 03 0000 000A C5CD")
+	response := withModbusCrc("01 03 04 0001 0002")
+	exception := withModbusCrc("01 83 02")
+	writeMultiple := withModbusCrc("11 10 0001 0002 04 000A 0102")
+
+	for _, frame := range [][]byte{request, response, exception, writeMultiple} {
+		stream := append(append([]byte{}, frame...), 0x01, 0x03)
+		res := rule.Split(stream)
+		if res.Abandoned || res.Advance != len(frame) || !bytes.Equal(res.Token, frame) {
+			t.Fatalf("frame %X: unexpected result advance %d, abandoned %v", frame, res.Advance, res.Abandoned)
+		}
+	}
+
+	// 报文不完整
+	res := rule.Split(response[:5])
+	if res.Advance != 0 || res.Abandoned {
+		t.Fatalf("expect wait, got advance %d", res.Advance)
+	}
+
+	// CRC 错误, 丢弃一个字节
+	corrupted := append([]byte{}, request...)
+	corrupted[4] ^= 0xFF
+	res = rule.Split(corrupted)
+	if !res.Abandoned || res.Advance != 1 {
+		t.Fatalf("expect abandon 1 byte, got advance %d, abandoned %v", res.Advance, res.Abandoned)
+	}
+
+	strip := &ModbusRtuRule{Direction: ModbusDirectionRequest, StripCrc: true}
+	if err := strip.Setup(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	res = strip.Split(request)
+	if !bytes.Equal(res.Token, request[:6]) {
+		t.Fatalf("unexpected token %X", res.Token)
+	}
+}
@@ -3,4 +3,7 @@ package framing
 func Register() {
 	registerBinary()
 	registerText()
+	registerEscape()
+	registerModbus()
+	registerHexText()
 }
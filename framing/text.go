@@ -10,20 +10,18 @@ import (
 const Text = "text"
 
 type TextRule struct {
-	StartDelimiter      string `yaml:"start_delimiter"`
-	EndDelimiter        string `yaml:"end_delimiter"`     // 结束符号
-	ContainDelimiter    bool   `yaml:"contain_delimiter"` // 返回的token是否包含分隔符
-	Length              int    `yaml:"length"`            // 固定长度, 和EndDelimiter须有一个有值, 如果设置了length,可以不设置max_len
-	MaxLen              int    `yaml:"max_len"`
+	StartDelimiter      string              `yaml:"start_delimiter"`   // 起始符号, 为空时协议没有报文头标记, 需要通过试解码确认报文
+	EndDelimiter        string              `yaml:"end_delimiter"`     // 结束符号
+	ContainDelimiter    bool                `yaml:"contain_delimiter"` // 返回的token是否包含分隔符
+	Length              int                 `yaml:"length"`            // 固定长度, 和EndDelimiter须有一个有值, 如果设置了length,可以不设置max_len
+	MaxLen              int                 `yaml:"max_len"`
+	Checksum            *core.FrameChecksum `yaml:"checksum"` // 报文校验, 针对返回的token, 分包时校验失败则丢弃一个字节重新同步
 	headerMarkerBytes   []byte
 	startDelimiterBytes []byte
 	endDelimiterBytes   []byte
 }
 
 func (this *TextRule) Setup() (err error) {
-	if this.StartDelimiter == "" {
-		return errors.New("BinaryRule.Setup: start_delimiter should not be empty")
-	}
 	if this.EndDelimiter == "" && this.Length == 0 {
 		return errors.New("TextRule.Setup: end_delimiter or max_len should not both be empty/null")
 	}
@@ -41,10 +39,14 @@ func (this *TextRule) Setup() (err error) {
 	if err != nil {
 		return err
 	}
-	return nil
+	return setupChecksum(this.Checksum, "TextRule")
 }
 
 func (this *TextRule) Split(data []byte) *core.FramingRuleMatchResult {
+	return verifyChecksum(this.Checksum, this.split(data), data)
+}
+
+func (this *TextRule) split(data []byte) *core.FramingRuleMatchResult {
 	if len(data) < len(this.startDelimiterBytes) {
 		// 这里应该是不会发生的
 		return core.ErrorFramingRuleMatchResult(
@@ -91,6 +93,24 @@ func (this *TextRule) Split(data []byte) *core.FramingRuleMatchResult {
 	return core.WaitFramingRuleMatchResult()
 }
 
+// Encode 添加起始和结束分隔符, contain_delimiter 时 fields 中已经包含了分隔符, 原样返回
+func (this *TextRule) Encode(payload []byte) ([]byte, error) {
+	payload, err := fillChecksum(this.Checksum, payload)
+	if err != nil {
+		return nil, err
+	}
+	if this.ContainDelimiter {
+		return payload, nil
+	}
+	ret := make([]byte, 0, len(this.startDelimiterBytes)+len(payload)+len(this.endDelimiterBytes))
+	ret = append(ret, this.startDelimiterBytes...)
+	ret = append(ret, payload...)
+	if this.Length == 0 {
+		ret = append(ret, this.endDelimiterBytes...)
+	}
+	return ret, nil
+}
+
 func (this *TextRule) GetHeaderMarker() []byte {
 	return this.headerMarkerBytes
 }
@@ -1,15 +1,34 @@
 package node
 
 import (
+	"bytes"
+	"encoding/hex"
 	"github.com/vuuvv/errors"
 	"github.com/vuuvv/vpacket/core"
 )
 
+const (
+	ArrayRepeatCount = ""      // 按 size/size_expr 的个数循环, 默认
+	ArrayRepeatEos   = "eos"   // 循环到数据结束(有 byte_size_expr 时为这些字节结束)
+	ArrayRepeatUntil = "until" // 循环到 until 条件成立或遇到 terminator
+
+	ArrayDefaultMaxItems = 65535
+)
+
+// ArrayNode 数组, 每个元素有自己的作用域, 元素中的字段名相对于当前元素, 如数组 data.items 的元素字段 port
+// 兼容以数组名为前缀的写法(data.items.port), 和数组名相同的字段表示元素本身(元素不是 map 时)
+// 元素中的表达式可以使用 item(当前元素), index(索引) 和 parent(上一层元素或根字段)
+// 编码时按输入列表的元素个数循环, 不需要 size/size_expr, repeat until 时检查元素和 until/terminator 一致
 type ArrayNode struct {
 	core.BaseNode
-	Item     []core.Node
-	Size     int
-	SizeExpr *core.CelEvaluator
+	Item         []core.Node
+	Size         int
+	SizeExpr     *core.CelEvaluator
+	Repeat       string
+	Until        *core.CelEvaluator
+	Terminator   []byte
+	ByteSizeExpr *core.CelEvaluator
+	MaxItems     int
 }
 
 func (n *ArrayNode) Compile(yf *core.YamlField, structures core.DataStructures) (err error) {
@@ -24,6 +43,45 @@ func (n *ArrayNode) Compile(yf *core.YamlField, structures core.DataStructures)
 		n.SizeExpr = expr
 	}
 
+	n.Repeat = yf.Repeat
+	if n.Repeat == ArrayRepeatCount && yf.ByteSizeExpr != "" {
+		n.Repeat = ArrayRepeatEos
+	}
+	switch n.Repeat {
+	case ArrayRepeatCount, ArrayRepeatEos:
+		if yf.Until != "" || yf.Terminator != "" {
+			return errors.Errorf("array %s: 'until' and 'terminator' require repeat 'until'", n.Name)
+		}
+	case ArrayRepeatUntil:
+		if yf.Until == "" && yf.Terminator == "" {
+			return errors.Errorf("array %s: repeat 'until' requires 'until' or 'terminator'", n.Name)
+		}
+	default:
+		return errors.Errorf("array %s: repeat should be eos or until, actual %s", n.Name, n.Repeat)
+	}
+	if yf.Until != "" {
+		if n.Until, err = core.CompileExpression(yf.Until); err != nil {
+			return errors.Wrapf(err, "Compile 'until' of field %s: %s", n.Name, err.Error())
+		}
+	}
+	if yf.Terminator != "" {
+		if n.Terminator, err = hex.DecodeString(yf.Terminator); err != nil {
+			return errors.Wrapf(err, "array %s: terminator should be hex", n.Name)
+		}
+	}
+	if yf.ByteSizeExpr != "" {
+		if n.ByteSizeExpr, err = core.CompileExpression(yf.ByteSizeExpr); err != nil {
+			return errors.Wrapf(err, "Compile 'byte_size_expr' of field %s: %s", n.Name, err.Error())
+		}
+	}
+	n.MaxItems = yf.MaxItems
+	if n.MaxItems == 0 {
+		n.MaxItems = ArrayDefaultMaxItems
+	}
+	if n.MaxItems < 0 {
+		return errors.Errorf("array %s: max_items should be positive, actual %d", n.Name, n.MaxItems)
+	}
+
 	n.Item, err = core.NodeCompileWithRef(yf.Ref, yf.Fields, structures, true)
 	if err != nil {
 		return errors.Wrapf(err, "compile 'item' failed: %s", err.Error())
@@ -33,44 +91,181 @@ func (n *ArrayNode) Compile(yf *core.YamlField, structures core.DataStructures)
 
 func (n *ArrayNode) Decode(ctx *core.Context) error {
 	var val []any
-	//ctx.SetField(n.Name, val)
-	//ctx.ArrayStack = append(ctx.ArrayStack, val)
-	//ctx.Array = val
-	//defer func() {
-	//	ctx.ArrayStack = ctx.ArrayStack[:len(ctx.ArrayStack)-1]
-	//	if len(ctx.ArrayStack) > 0 {
-	//		ctx.Array = ctx.Array[:len(ctx.Array)-1]
-	//	}
-	//}()
-	length, err := ctx.GetSize(n.Size, n.SizeExpr)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	for i := 0; i < length; i++ {
-		err = core.NodeDecode(ctx, n.Item...)
+
+	// byte_size_expr 限制数组能读取的数据, 结束后恢复
+	if n.ByteSizeExpr != nil {
+		byteSize, err := ctx.GetSize(0, n.ByteSizeExpr)
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		item, ok := ctx.GetField(n.Name)
-		if ok {
+		if byteSize < 0 || ctx.BytePos+byteSize > len(ctx.Data) {
+			return errors.Errorf("array %s: byte size %d out of range, have %d", n.Name, byteSize, len(ctx.Data)-ctx.BytePos)
+		}
+		data := ctx.Data
+		end := ctx.BytePos + byteSize
+		ctx.Data = data[:end]
+		defer func() {
+			ctx.Data = data
+			if n.Repeat == ArrayRepeatUntil {
+				ctx.BytePos = end // until 提前结束时跳过剩余的字节
+			}
+		}()
+	}
+
+	length := n.MaxItems
+	if n.Repeat == ArrayRepeatCount {
+		var err error
+		if length, err = ctx.GetSize(n.Size, n.SizeExpr); err != nil {
+			return errors.WithStack(err)
+		}
+		if length > n.MaxItems {
+			return errors.Errorf("array %s: size %d exceeds max_items %d", n.Name, length, n.MaxItems)
+		}
+	}
+
+	for i := 0; ; i++ {
+		if n.Repeat != ArrayRepeatCount && ctx.BytePos >= len(ctx.Data) && ctx.BitPos == 0 {
+			if n.Repeat == ArrayRepeatUntil && n.ByteSizeExpr == nil {
+				return errors.Errorf("array %s: EOF before until condition or terminator", n.Name)
+			}
+			break
+		}
+		if n.Terminator != nil && bytes.HasPrefix(ctx.Data[ctx.BytePos:], n.Terminator) {
+			ctx.BytePos += len(n.Terminator)
+			break
+		}
+		if i >= length {
+			if n.Repeat == ArrayRepeatCount {
+				break
+			}
+			return errors.Errorf("array %s: items exceed max_items %d", n.Name, n.MaxItems)
+		}
+
+		pos := ctx.BytePos*8 + ctx.BitPos
+		scope := ctx.PushScope(n.Name, i, nil)
+		err := core.NodeDecode(ctx, n.Item...)
+		ctx.PopScope()
+		if err != nil {
+			return errors.Wrapf(err, "array %s: decode item %d failed", n.Name, i)
+		}
+		item := scope.Value
+		if item != nil {
 			val = append(val, item)
 		}
-		ctx.SetField(n.Name, nil)
+		if n.Repeat != ArrayRepeatCount && ctx.BytePos*8+ctx.BitPos == pos {
+			return errors.Errorf("array %s: item %d consumed no data", n.Name, i)
+		}
+
+		if n.Until != nil {
+			stop, err := n.until(ctx, item)
+			if err != nil {
+				return err
+			}
+			if stop {
+				break
+			}
+		}
 	}
 	ctx.SetField(n.Name, val)
 	return nil
 }
 
-func (n *ArrayNode) Encode(ctx *core.Context) error {
-	length, err := ctx.GetSize(n.Size, n.SizeExpr)
+// until 元素是否满足 until 条件
+func (n *ArrayNode) until(ctx *core.Context, item any) (bool, error) {
+	res, err := n.Until.ExecuteWithValue(ctx, item)
+	if err != nil {
+		return false, errors.Wrapf(err, "array %s: execute until failed", n.Name)
+	}
+	stop, ok := res.(bool)
+	if !ok {
+		return false, errors.Errorf("array %s: until should return bool, actual %T", n.Name, res)
+	}
+	return stop, nil
+}
+
+// checkUntil 检查 repeat until 的编码结果能被解码为相同的元素: 前面的元素不能满足 until 或以 terminator 开头,
+// 没有 terminator 和 byte_size_expr 时, 只有最后一个元素满足 until
+func (n *ArrayNode) checkUntil(ctx *core.Context, index int, length int, item any, encoded []byte) error {
+	if n.Terminator != nil && bytes.HasPrefix(encoded, n.Terminator) {
+		return errors.Errorf("array %s: item %d starts with terminator %X", n.Name, index, n.Terminator)
+	}
+	if n.Until == nil {
+		return nil
+	}
+	stop, err := n.until(ctx, item)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
+	}
+	if stop && index < length-1 {
+		return errors.Errorf("array %s: until is true at item %d, but there are %d items", n.Name, index, length)
+	}
+	if !stop && index == length-1 && n.Terminator == nil && n.ByteSizeExpr == nil {
+		return errors.Errorf("array %s: until should be true at the last item %d", n.Name, index)
+	}
+	return nil
+}
+
+func (n *ArrayNode) Encode(ctx *core.Context) error {
+	input, hasInput := ctx.GetLocalField(n.Name)
+	var list []any
+	switch v := input.(type) {
+	case []any:
+		list = v
+	case []map[string]any:
+		for _, item := range v {
+			list = append(list, item)
+		}
+	case nil:
+		hasInput = false
+	default:
+		return errors.Errorf("array %s should be a list, actual %T", n.Name, input)
+	}
+
+	length := len(list)
+	if !hasInput {
+		if err := ctx.FieldMissing(n.Name); err != nil {
+			return err
+		}
+		if n.Repeat != ArrayRepeatCount {
+			length = 0
+		} else {
+			var err error
+			if length, err = ctx.GetSize(n.Size, n.SizeExpr); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	} else if n.Repeat == ArrayRepeatCount && n.SizeExpr == nil && n.Size > 0 && length != n.Size {
+		return errors.Errorf("array %s size should be %d, actual %d", n.Name, n.Size, length)
+	}
+	if length > n.MaxItems {
+		return errors.Errorf("array %s: items %d exceed max_items %d", n.Name, length, n.MaxItems)
 	}
+	// 只有 until 条件时, 解码至少读取一个元素
+	verify := n.Repeat == ArrayRepeatUntil && ctx.Round == 0
+	if verify && length == 0 && n.Terminator == nil && n.ByteSizeExpr == nil {
+		return errors.Errorf("array %s: repeat until requires at least one item", n.Name)
+	}
+
 	for i := 0; i < length; i++ {
-		err = core.NodeEncode(ctx, n.Item...)
+		var item any
+		if hasInput {
+			item = list[i]
+		}
+		start := ctx.Writer.Len()
+		ctx.PushScope(n.Name, i, item)
+		err := core.NodeEncode(ctx, n.Item...)
+		ctx.PopScope()
 		if err != nil {
-			return errors.WithStack(err)
+			return errors.Wrapf(err, "array %s: encode item %d failed", n.Name, i)
 		}
+		if verify {
+			if err = n.checkUntil(ctx, i, length, item, ctx.Writer.Bytes()[start:]); err != nil {
+				return err
+			}
+		}
+	}
+	if n.Terminator != nil && ctx.Round == 0 {
+		return ctx.WriteBytes(n.Terminator)
 	}
 	return nil
 }
@@ -0,0 +1,154 @@
+package node
+
+import (
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+	"github.com/vuuvv/vpacket/utils"
+	"strconv"
+)
+
+// BitfieldNode 位域, 在 1-8 个字节上声明多个按位的子字段, 解码为 {子字段名: 值}, 编码时从同样的 map 写回
+// 子字段按声明顺序排列, msb 时第一个子字段在最高位, lsb 时第一个子字段在最低位
+// 子字段的总位数可以小于 size * 8, 剩下的位为保留位, 编码时为 0; 没有名称的子字段也是保留位
+// 子字段支持 bits, type(int 为有符号数, 其它为无符号数) 和 default
+type BitfieldNode struct {
+	core.BaseNode
+	core.BaseEncodable
+	Size     int
+	BitOrder string
+	Members  []*bitfieldMember
+}
+
+type bitfieldMember struct {
+	Name    string
+	Bits    int
+	Signed  bool
+	Default int64
+}
+
+func (n *BitfieldNode) Compile(yf *core.YamlField, structures core.DataStructures) error {
+	_ = n.BaseNode.Compile(yf, structures)
+	if err := n.BaseEncodable.Compile(yf, structures); err != nil {
+		return errors.WithStack(err)
+	}
+	n.Size = yf.Size
+	if n.Size < 1 || n.Size > 8 {
+		return errors.Errorf("bitfield %s: size should between 1 and 8, actual %d", n.Name, n.Size)
+	}
+	n.BitOrder = yf.BitOrder
+	if n.BitOrder != "" && n.BitOrder != core.BitOrderMsb && n.BitOrder != core.BitOrderLsb {
+		return errors.Errorf("bitfield %s: bit_order should be msb or lsb, actual %s", n.Name, n.BitOrder)
+	}
+
+	total := 0
+	for _, f := range yf.Fields {
+		if f.Bits <= 0 {
+			return errors.Errorf("bitfield %s: bits of '%s' should be positive", n.Name, f.Name)
+		}
+		member := &bitfieldMember{Name: f.Name, Bits: f.Bits, Signed: f.Type == core.NodeTypeInt}
+		if !f.Default.IsZero() {
+			val, err := utils.YamlDecode[string](&f.Default)
+			if err != nil {
+				return err
+			}
+			if member.Default, err = strconv.ParseInt(*val, 0, 64); err != nil {
+				return errors.Wrapf(err, "bitfield %s: invalid default of '%s'", n.Name, f.Name)
+			}
+		}
+		total += f.Bits
+		n.Members = append(n.Members, member)
+	}
+	if total == 0 || total > n.Size*8 {
+		return errors.Errorf("bitfield %s: total bits should between 1 and %d, actual %d", n.Name, n.Size*8, total)
+	}
+	return nil
+}
+
+// shifts 每个子字段在整个位域中的右移位数
+func (n *BitfieldNode) shifts() []int {
+	shifts := make([]int, len(n.Members))
+	pos := 0
+	for i, m := range n.Members {
+		if n.BitOrder == core.BitOrderLsb {
+			shifts[i] = pos
+		} else {
+			shifts[i] = n.Size*8 - pos - m.Bits
+		}
+		pos += m.Bits
+	}
+	return shifts
+}
+
+func (n *BitfieldNode) Decode(ctx *core.Context) error {
+	bs, err := ctx.ReadBytes(n.Size)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	raw, err := utils.ConvertBytesToInt(bs, n.ByteOrder)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	val := make(map[string]any, len(n.Members))
+	for i, shift := range n.shifts() {
+		m := n.Members[i]
+		if m.Name == "" {
+			continue
+		}
+		v := raw >> shift & (1<<m.Bits - 1)
+		if m.Signed {
+			val[m.Name] = utils.SignExtend(v, m.Bits)
+		} else {
+			val[m.Name] = v
+		}
+	}
+	ctx.SetField(n.Name, val)
+	return nil
+}
+
+func (n *BitfieldNode) Encode(ctx *core.Context) error {
+	if ctx.Round > n.GetRound() { // 编译的轮次大于节点轮次，跳过
+		return nil
+	}
+	if ctx.Round < n.GetRound() { // 编译的轮次小于节点轮次，写入占位符
+		return ctx.WritePlaceholder(n.Size)
+	}
+
+	values := map[string]any{}
+	if val, ok := ctx.GetLocalField(n.Name); ok {
+		if values, ok = val.(map[string]any); !ok {
+			return errors.Errorf("bitfield %s should be a map, actual %T", n.Name, val)
+		}
+	} else if err := ctx.FieldMissing(n.Name); err != nil {
+		return err
+	}
+
+	var raw uint64
+	for i, shift := range n.shifts() {
+		m := n.Members[i]
+		var val any = m.Default
+		if v, ok := values[m.Name]; ok && m.Name != "" {
+			val = v
+		}
+		var u uint64
+		var err error
+		if m.Signed {
+			iv, ok := utils.ToInt64(val)
+			if !ok {
+				return errors.Errorf("bitfield %s: '%s' should be a int, actual '%v'", n.Name, m.Name, val)
+			}
+			u, err = utils.IntToBits(iv, m.Bits)
+		} else {
+			u, err = utils.UintToBits(val, m.Bits)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "bitfield %s: '%s'", n.Name, m.Name)
+		}
+		raw |= u << shift
+	}
+	return ctx.WriteInt(raw, n.Size, n.ByteOrder)
+}
+
+func registerBitfield() {
+	core.RegisterNodeCompilerFactory[BitfieldNode](core.NodeTypeBitfield, false)
+}
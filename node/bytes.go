@@ -1,12 +1,13 @@
 package node
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"github.com/vuuvv/errors"
 	"github.com/vuuvv/vpacket/core"
 	"github.com/vuuvv/vpacket/utils"
+	"math"
+	"strconv"
 )
 
 type BytesNode struct {
@@ -18,6 +19,12 @@ type BytesNode struct {
 	Default    []byte
 	HasDefault bool
 	Bits       int
+	BitOrder   string
+	bitDefault any     // 不按字节对齐的 bits 的默认值
+	Scale      float64 // 数值的比例, 为 0 时不缩放
+	Offset     float64
+	divisor    float64    // scale 为 1/n 时的 n, 用除法计算避免 2315 * 0.01 = 23.150000000000002
+	enum       *enumTable // 值到标签的映射
 	Check      *core.CelEvaluator
 	Crc        string
 	CrcStart   *core.CelEvaluator
@@ -32,6 +39,33 @@ func (this *BytesNode) Compile(yf *core.YamlField, structures core.DataStructure
 	}
 	this.Size = yf.Size
 	this.Bits = yf.Bits
+	this.BitOrder = yf.BitOrder
+	if this.BitOrder != "" && this.BitOrder != core.BitOrderMsb && this.BitOrder != core.BitOrderLsb {
+		return errors.Errorf("field %s: bit_order should be msb or lsb, actual %s", this.Name, this.BitOrder)
+	}
+	if this.Bits%8 != 0 && yf.Round != 0 {
+		return errors.Errorf("field %s: bits not aligned to bytes should not set round", this.Name)
+	}
+	this.Scale = yf.Scale
+	this.Offset = yf.Offset
+	if yf.Decimals < 0 {
+		return errors.Errorf("field %s: decimals should not be negative", this.Name)
+	}
+	if yf.Decimals > 0 {
+		if this.Scale != 0 {
+			return errors.Errorf("field %s: scale and decimals should not both be set", this.Name)
+		}
+		this.Scale = math.Pow10(-yf.Decimals)
+		this.divisor = math.Pow10(yf.Decimals)
+	}
+	if this.Offset != 0 && this.Scale == 0 {
+		this.Scale = 1
+	}
+	if this.Scale != 0 && this.divisor == 0 {
+		if inv := 1 / this.Scale; math.Abs(inv-math.Round(inv)) < inv*1e-9 {
+			this.divisor = math.Round(inv)
+		}
+	}
 	this.Type = yf.Type
 	this.Crc = yf.Crc
 	this.HasDefault = !yf.Default.IsZero()
@@ -39,6 +73,28 @@ func (this *BytesNode) Compile(yf *core.YamlField, structures core.DataStructure
 	if this.Type == "" {
 		this.Type = core.NodeTypeHex
 	}
+	if this.Crc != "" {
+		this.Type = core.NodeTypeUint
+	}
+	// 按字节对齐的 bits 按整数读取, 未指定 int 时为无符号整数
+	if this.Bits > 0 && this.Bits%8 == 0 {
+		this.Size = this.Bits / 8
+		if this.Type != core.NodeTypeInt {
+			this.Type = core.NodeTypeUint
+		}
+	}
+	if this.Scale != 0 && this.Bits == 0 && !isNumeric(this.Type) {
+		return errors.Errorf("field %s: scale/offset/decimals only support numeric types", this.Name)
+	}
+
+	if yf.Enum != "" {
+		if this.Crc != "" || this.Scale != 0 {
+			return errors.Errorf("field %s: enum should not be used with crc or scale", this.Name)
+		}
+		if this.enum, err = newEnumTable(yf.Enum, this.Type, yf.EnumUnknown, structures); err != nil {
+			return errors.Wrapf(err, "Compile 'enum' of field %s", this.Name)
+		}
+	}
 
 	if yf.SizeExpr != "" {
 		expr, err := core.CompileExpression(yf.SizeExpr)
@@ -78,6 +134,12 @@ func (this *BytesNode) Compile(yf *core.YamlField, structures core.DataStructure
 		if err != nil {
 			return err
 		}
+		if this.Bits%8 != 0 {
+			if this.bitDefault, err = strconv.ParseInt(*defaultVal, 0, 64); err != nil {
+				return errors.Wrapf(err, "Compile 'default' of field %s: %s", this.Name, err.Error())
+			}
+			return nil
+		}
 		this.Default, err = utils.ParseTValue(*defaultVal, this.Size, this.ByteOrder)
 		if err != nil {
 			return errors.Wrapf(err, "Compile 'default' of field %s: %s", this.Name, err.Error())
@@ -90,22 +152,16 @@ func (this *BytesNode) Compile(yf *core.YamlField, structures core.DataStructure
 func (this *BytesNode) Decode(ctx *core.Context) (err error) {
 	var val any
 
-	if this.Crc != "" {
-		this.Type = "uint"
-	}
-
-	if this.Bits > 0 {
-		if this.Bits%8 != 0 {
-			val, err = this.readBits(ctx)
-			if err != nil {
-				return err
-			}
-			ctx.SetField(this.Name, val)
-			return nil
-		} else {
-			this.Size = this.Bits / 8
-			this.Type = "uint"
+	if this.Bits%8 != 0 {
+		val, err = this.readBits(ctx)
+		if err != nil {
+			return err
+		}
+		if this.enum != nil {
+			return this.enum.setField(ctx, this.Name, val)
 		}
+		ctx.SetField(this.Name, val)
+		return nil
 	}
 	if this.Size == 0 && this.SizeExpr == nil {
 		return errors.New("should specify a size or bits or size_expr")
@@ -114,7 +170,13 @@ func (this *BytesNode) Decode(ctx *core.Context) (err error) {
 	if err != nil {
 		return err
 	}
-	ctx.SetField(this.Name, val)
+	if this.enum != nil {
+		if err = this.enum.setField(ctx, this.Name, val); err != nil {
+			return err
+		}
+	} else {
+		ctx.SetField(this.Name, val)
+	}
 
 	if this.Crc != "" {
 		crcVal, err := this.crc(ctx)
@@ -144,11 +206,15 @@ func (this *BytesNode) Decode(ctx *core.Context) (err error) {
 }
 
 func (this *BytesNode) readBits(ctx *core.Context) (any, error) {
-	val, err := ctx.ReadBits(this.Bits)
+	val, err := ctx.ReadBitsWithOrder(this.Bits, this.BitOrder)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return val, nil
+	var ret any = val
+	if this.Type == core.NodeTypeInt {
+		ret = utils.SignExtend(val, this.Bits)
+	}
+	return this.scale(ret), nil
 }
 
 func (this *BytesNode) readBytes(ctx *core.Context) (any, error) {
@@ -174,22 +240,32 @@ func (this *BytesNode) readBytes(ctx *core.Context) (any, error) {
 		return string(bytesVal), nil
 	case core.NodeTypeInt:
 		v, err := utils.ConvertBytesToInt(bytesVal, byteOrder)
-		return int64(v), errors.WithStack(err)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return this.scale(utils.SignExtend(v, len(bytesVal)*8)), nil
 	case core.NodeTypeUint:
 		v, err := utils.ConvertBytesToInt(bytesVal, byteOrder)
-		return v, errors.WithStack(err)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return this.scale(v), nil
 	case core.NodeTypeFloat:
-		bytesLen := len(bytesVal)
-		if bytesLen == 32 {
-			var v float32
-			err = binary.Read(bytes.NewBuffer(bytesVal), byteOrder, &v)
-			return v, errors.WithStack(err)
-		} else if bytesLen == 64 {
-			var v float64
-			err = binary.Read(bytes.NewBuffer(bytesVal), byteOrder, &v)
-			return v, errors.WithStack(err)
-		}
-		return nil, errors.Errorf("float size should be 4 or 8, actual %d", bytesLen)
+		v, err := utils.ConvertBytesToInt(bytesVal, byteOrder)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		switch len(bytesVal) {
+		case 2:
+			return this.scale(utils.Float16ToFloat32(uint16(v))), nil
+		case 4:
+			return this.scale(math.Float32frombits(uint32(v))), nil
+		case 8:
+			return this.scale(math.Float64frombits(v)), nil
+		}
+		return nil, errors.Errorf("float size should be 2, 4 or 8, actual %d", len(bytesVal))
+	case core.NodeTypeBcd, core.NodeTypePacked, core.NodeTypeBcdString:
+		return this.readBcd(bytesVal, byteOrder)
 	default:
 		return nil, errors.Errorf("unsupported type: %s", this.Type)
 	}
@@ -242,6 +318,10 @@ func (this *BytesNode) crc(ctx *core.Context) (uint64, error) {
 }
 
 func (this *BytesNode) Encode(ctx *core.Context) error {
+	if this.Bits%8 != 0 {
+		return this.writeBits(ctx)
+	}
+
 	size, err := ctx.GetSize(this.Size, this.SizeExpr)
 	if err != nil {
 		return errors.WithStack(err)
@@ -263,27 +343,154 @@ func (this *BytesNode) Encode(ctx *core.Context) error {
 	var ok bool
 
 	if this.Crc != "" {
-		this.Type = "uint"
 		crcVal, err := this.crc(ctx)
 		if err != nil {
 			return err
 		}
 		val = crcVal
 	} else {
-		val, ok = ctx.GetField(this.Name)
+		val, ok = ctx.GetLocalField(this.Name)
 		if !ok {
 			// 如果从输入中没有获取到对应的字段, 则根据是否有默认值来判断是否写入默认值, 没有的话写0补充
 			if this.HasDefault {
 				return ctx.WriteBytes(this.Default)
-			} else {
-				return ctx.WritePlaceholder(size)
 			}
+			if err = ctx.FieldMissing(this.Name); err != nil {
+				return err
+			}
+			return ctx.WritePlaceholder(size)
 		}
 	}
 
+	if this.Crc == "" && this.Scale != 0 {
+		if val, err = this.unscale(val); err != nil {
+			return err
+		}
+	}
+	if this.enum != nil {
+		if val, err = this.enum.encode(val); err != nil {
+			return errors.Wrapf(err, "field %s", this.Name)
+		}
+	}
 	return ctx.Write(this.Type, val, size, this)
 }
 
+// writeBits 写入不按字节对齐的 bits, 没有值时写入默认值或 0
+func (this *BytesNode) writeBits(ctx *core.Context) (err error) {
+	if ctx.Round > 0 {
+		return nil
+	}
+	val, ok := ctx.GetLocalField(this.Name)
+	if !ok {
+		val = this.bitDefault
+		if val == nil {
+			if err = ctx.FieldMissing(this.Name); err != nil {
+				return err
+			}
+			val = 0
+		}
+	} else if this.Scale != 0 {
+		if val, err = this.unscale(val); err != nil {
+			return err
+		}
+	} else if this.enum != nil {
+		if val, err = this.enum.encode(val); err != nil {
+			return errors.Wrapf(err, "field %s", this.Name)
+		}
+	}
+
+	var u uint64
+	if this.Type == core.NodeTypeInt {
+		i, ok := utils.ToInt64(val)
+		if !ok {
+			return errors.Errorf("value should be a int, '%v'", val)
+		}
+		u, err = utils.IntToBits(i, this.Bits)
+	} else {
+		u, err = utils.UintToBits(val, this.Bits)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.WriteBits(u, this.Bits, this.BitOrder)
+}
+
+// readBcd BCD 码解码, 小端时字节逆序
+func (this *BytesNode) readBcd(bytesVal []byte, byteOrder binary.ByteOrder) (any, error) {
+	bytesVal = utils.ReorderBytes(bytesVal, byteOrder)
+	if this.Type == core.NodeTypePacked {
+		digits, negative, err := utils.DecodePackedBcd(bytesVal)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s", this.Name)
+		}
+		v, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s: packed bcd overflows int64", this.Name)
+		}
+		if negative {
+			v = -v
+		}
+		return this.scale(v), nil
+	}
+
+	digits, err := utils.DecodeBcd(bytesVal)
+	if err != nil {
+		return nil, errors.Wrapf(err, "field %s", this.Name)
+	}
+	if this.Type == core.NodeTypeBcdString {
+		return digits, nil
+	}
+	v, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "field %s: bcd overflows uint64, use bcd_string instead", this.Name)
+	}
+	return this.scale(v), nil
+}
+
+// scale 原始值按 scale/offset 转换为浮点数, 没有设置 scale 时原样返回
+func (this *BytesNode) scale(raw any) any {
+	if this.Scale == 0 {
+		return raw
+	}
+	f, _ := utils.ToFloat64(raw)
+	if this.divisor != 0 {
+		return f/this.divisor + this.Offset
+	}
+	return f*this.Scale + this.Offset
+}
+
+// unscale 把数值按 scale/offset 还原为原始值, 整数类型四舍五入
+func (this *BytesNode) unscale(val any) (any, error) {
+	f, ok := utils.ToFloat64(val)
+	if !ok {
+		return nil, errors.Errorf("value should be a number, '%v'", val)
+	}
+	f -= this.Offset
+	if this.divisor != 0 {
+		f *= this.divisor
+	} else {
+		f /= this.Scale
+	}
+	switch this.Type {
+	case core.NodeTypeFloat:
+		return f, nil
+	case core.NodeTypeInt, core.NodeTypePacked:
+		return int64(math.Round(f)), nil
+	}
+	if f < 0 {
+		return nil, errors.Errorf("value %v is out of range for uint after scale", val)
+	}
+	return uint64(math.Round(f)), nil
+}
+
+func isNumeric(typ string) bool {
+	switch typ {
+	case core.NodeTypeInt, core.NodeTypeUint, core.NodeTypeFloat, core.NodeTypeBcd, core.NodeTypePacked:
+		return true
+	}
+	return false
+}
+
 func registerBytes() {
 	core.RegisterNodeCompilerFactory[BytesNode](core.NodeTypeBytes, true)
 }
@@ -3,6 +3,7 @@ package node
 import (
 	"github.com/vuuvv/errors"
 	"github.com/vuuvv/vpacket/core"
+	"github.com/vuuvv/vpacket/utils"
 )
 
 type CalcNode struct {
@@ -68,26 +69,8 @@ func (n *CalcNode) Encode(ctx *core.Context) error {
 	switch v := val.(type) {
 	case []byte:
 		return ctx.WriteBytes(v[:size])
-	case int:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
-	case int8:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
-	case int16:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
-	case int32:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
-	case int64:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
-	case uint:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
-	case uint8:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
-	case uint16:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
-	case uint32:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
-	case uint64:
-		return ctx.Write(core.NodeTypeInt, v, size, n)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return ctx.Write(intType(v), v, size, n)
 	case string:
 		return ctx.Write(core.NodeTypeString, v, size, n)
 	case float64:
@@ -98,6 +81,14 @@ func (n *CalcNode) Encode(ctx *core.Context) error {
 	return nil
 }
 
+// intType 整数结果的编码类型, 负数按补码编码, 其它按无符号整数编码, 都会检查是否超出 size 的范围
+func intType(val any) string {
+	if i, ok := utils.ToInt64(val); ok && i < 0 {
+		return core.NodeTypeInt
+	}
+	return core.NodeTypeUint
+}
+
 func registerCalc() {
 	core.RegisterNodeCompilerFactory[CalcNode](core.NodeTypeCalc, false)
 }
@@ -0,0 +1,234 @@
+package node
+
+import (
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+	"github.com/vuuvv/vpacket/utils"
+	"strconv"
+	"time"
+)
+
+const (
+	DatetimeFormatUnix   = "unix"    // Unix 秒, 默认 4 字节, 也可以是 8 字节
+	DatetimeFormatUnixMs = "unix_ms" // Unix 毫秒, 默认 8 字节, 也可以是 6 字节
+	DatetimeFormatBcd    = "bcd"     // BCD 码的 YYMMDDhhmmss, 6 字节
+	DatetimeFormatBinary = "binary"  // 二进制的年(2000 起)月日时分秒, 6 字节
+
+	DatetimeOutputRfc3339 = "rfc3339" // 解码为 RFC 3339 字符串
+	DatetimeOutputTime    = "time"    // 解码为 time.Time
+
+	DatetimeDefaultNow = "now" // 编码时没有值则使用当前时间
+)
+
+// DatetimeNode 日期时间, 解码为 RFC 3339 字符串或 time.Time
+// 编码时接受 time.Time, RFC 3339 字符串或者数字, 数字的单位和 format 相同, bcd 和 binary 为 Unix 秒
+// bcd 和 binary 格式没有时区信息, 按 timezone 解释, timezone 默认为本地时区
+type DatetimeNode struct {
+	core.BaseNode
+	core.BaseEncodable
+	Format     string
+	Output     string
+	Size       int
+	Location   *time.Location
+	DefaultNow bool
+}
+
+func (n *DatetimeNode) Compile(yf *core.YamlField, structures core.DataStructures) (err error) {
+	_ = n.BaseNode.Compile(yf, structures)
+	if err = n.BaseEncodable.Compile(yf, structures); err != nil {
+		return errors.WithStack(err)
+	}
+	n.Format = yf.Format
+	if n.Format == "" {
+		n.Format = DatetimeFormatUnix
+	}
+	n.Output = yf.Output
+	if n.Output == "" {
+		n.Output = DatetimeOutputRfc3339
+	}
+	if n.Output != DatetimeOutputRfc3339 && n.Output != DatetimeOutputTime {
+		return errors.Errorf("datetime field %s: output should be rfc3339 or time, actual %s", n.Name, n.Output)
+	}
+
+	n.Size = yf.Size
+	switch n.Format {
+	case DatetimeFormatUnix:
+		if n.Size == 0 {
+			n.Size = 4
+		}
+	case DatetimeFormatUnixMs:
+		if n.Size == 0 {
+			n.Size = 8
+		}
+	case DatetimeFormatBcd, DatetimeFormatBinary:
+		if n.Size == 0 {
+			n.Size = 6
+		}
+		if n.Size != 6 {
+			return errors.Errorf("datetime field %s: size of format %s should be 6, actual %d", n.Name, n.Format, n.Size)
+		}
+	default:
+		return errors.Errorf("datetime field %s: unsupported format %s", n.Name, n.Format)
+	}
+	if n.Size < 1 || n.Size > 8 {
+		return errors.Errorf("datetime field %s: size should between 1 and 8, actual %d", n.Name, n.Size)
+	}
+
+	n.Location = time.Local
+	if yf.Timezone != "" {
+		n.Location, err = time.LoadLocation(yf.Timezone)
+		if err != nil {
+			return errors.Wrapf(err, "datetime field %s: invalid timezone %s", n.Name, yf.Timezone)
+		}
+	}
+
+	if !yf.Default.IsZero() {
+		val, err := utils.YamlDecode[string](&yf.Default)
+		if err != nil {
+			return err
+		}
+		if *val != DatetimeDefaultNow {
+			return errors.Errorf("datetime field %s: default should be 'now', actual %s", n.Name, *val)
+		}
+		n.DefaultNow = true
+	}
+	return nil
+}
+
+func (n *DatetimeNode) Decode(ctx *core.Context) error {
+	bs, err := ctx.ReadBytes(n.Size)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	t, err := n.decode(bs)
+	if err != nil {
+		return errors.Wrapf(err, "datetime field %s", n.Name)
+	}
+	t = t.In(n.Location)
+	if n.Output == DatetimeOutputTime {
+		ctx.SetField(n.Name, t)
+	} else {
+		ctx.SetField(n.Name, t.Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+func (n *DatetimeNode) decode(bs []byte) (time.Time, error) {
+	switch n.Format {
+	case DatetimeFormatUnix, DatetimeFormatUnixMs:
+		v, err := utils.ConvertBytesToInt(bs, n.ByteOrder)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if n.Format == DatetimeFormatUnix {
+			return time.Unix(int64(v), 0), nil
+		}
+		return time.UnixMilli(int64(v)), nil
+	}
+
+	// bcd 和 binary 都是 2000 年起的年月日时分秒, 和编码时的年份范围一致
+	var parts [6]int
+	if n.Format == DatetimeFormatBcd {
+		digits, err := utils.DecodeBcd(bs)
+		if err != nil {
+			return time.Time{}, err
+		}
+		for i := range parts {
+			parts[i] = int(digits[2*i]-'0')*10 + int(digits[2*i+1]-'0')
+		}
+	} else {
+		for i := range parts {
+			parts[i] = int(bs[i])
+		}
+	}
+	t := time.Date(2000+parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, n.Location)
+	if t.Month() != time.Month(parts[1]) || t.Day() != parts[2] || parts[3] > 23 || parts[4] > 59 || parts[5] > 59 {
+		return time.Time{}, errors.Errorf("invalid %s datetime %X", n.Format, bs)
+	}
+	return t, nil
+}
+
+func (n *DatetimeNode) Encode(ctx *core.Context) error {
+	if ctx.Round > n.GetRound() { // 编译的轮次大于节点轮次，跳过
+		return nil
+	}
+	if ctx.Round < n.GetRound() { // 编译的轮次小于节点轮次，写入占位符
+		return ctx.WritePlaceholder(n.Size)
+	}
+
+	var t time.Time
+	val, ok := ctx.GetLocalField(n.Name)
+	if !ok {
+		if !n.DefaultNow {
+			if err := ctx.FieldMissing(n.Name); err != nil {
+				return err
+			}
+			return ctx.WritePlaceholder(n.Size)
+		}
+		t = time.Now()
+	} else {
+		var err error
+		if t, err = n.toTime(val); err != nil {
+			return err
+		}
+	}
+
+	bs, err := n.encode(t.In(n.Location))
+	if err != nil {
+		return errors.Wrapf(err, "datetime field %s", n.Name)
+	}
+	return ctx.WriteBytes(bs)
+}
+
+// toTime 编码的值转换为时间
+func (n *DatetimeNode) toTime(val any) (time.Time, error) {
+	switch v := val.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		return *v, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, nil
+		}
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return time.Time{}, errors.Errorf("datetime field %s: value should be RFC 3339 or a number, actual '%s'", n.Name, v)
+		}
+	}
+	i, ok := utils.ToInt64(val)
+	if !ok {
+		return time.Time{}, errors.Errorf("datetime field %s: unsupported value %v", n.Name, val)
+	}
+	if n.Format == DatetimeFormatUnixMs {
+		return time.UnixMilli(i), nil
+	}
+	return time.Unix(i, 0), nil
+}
+
+func (n *DatetimeNode) encode(t time.Time) ([]byte, error) {
+	switch n.Format {
+	case DatetimeFormatUnix, DatetimeFormatUnixMs:
+		v := t.Unix()
+		if n.Format == DatetimeFormatUnixMs {
+			v = t.UnixMilli()
+		}
+		u, err := utils.UintToBits(v, n.Size*8)
+		if err != nil {
+			return nil, err
+		}
+		return utils.Uint64ToBytes(u, n.Size, n.ByteOrder), nil
+	case DatetimeFormatBcd:
+		if t.Year() < 2000 || t.Year() > 2099 {
+			return nil, errors.Errorf("year %d out of range 2000-2099", t.Year())
+		}
+		return utils.EncodeBcd(t.Format("060102150405"), n.Size)
+	}
+	if t.Year() < 2000 || t.Year() > 2255 {
+		return nil, errors.Errorf("year %d out of range 2000-2255", t.Year())
+	}
+	return []byte{byte(t.Year() - 2000), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute()), byte(t.Second())}, nil
+}
+
+func registerDatetime() {
+	core.RegisterNodeCompilerFactory[DatetimeNode](core.NodeTypeDatetime, false)
+}
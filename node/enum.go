@@ -0,0 +1,153 @@
+package node
+
+import (
+	"fmt"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+	"github.com/vuuvv/vpacket/utils"
+	"strconv"
+	"strings"
+)
+
+const (
+	EnumValueKey    = "value"  // 编码时 {"value": 值, "label": 标签} 格式中的值
+	EnumLabelKey    = "label"  // 编码时 {"value": 值, "label": 标签} 格式中的标签
+	EnumLabelSuffix = "_label" // 解码时标签保存在字段名加上该后缀的字段中, 字段本身保留原始值
+
+	EnumUnknownRaw   = "raw"   // 未知的值使用值本身作为标签, 默认
+	EnumUnknownNull  = "null"  // 未知的值标签为空
+	EnumUnknownError = "error" // 未知的值报错
+)
+
+// enumTable 值到标签的映射, 值按字段类型规范化后比较: 数字类型比较数值, hex 不区分大小写
+type enumTable struct {
+	name    string
+	numeric bool
+	unknown string
+	labels  map[string]string // 规范化的值 -> 标签
+	values  map[string]any    // 标签 -> 表中的值
+}
+
+func newEnumTable(ref string, typ string, unknown string, structures core.DataStructures) (*enumTable, error) {
+	structure, ok := structures[ref]
+	if !ok {
+		return nil, errors.Errorf("enum '%s' not found", ref)
+	}
+	if len(structure.Enum) == 0 {
+		return nil, errors.Errorf("enum '%s' should not be empty", ref)
+	}
+	if unknown == "" {
+		unknown = EnumUnknownRaw
+	}
+	if unknown != EnumUnknownRaw && unknown != EnumUnknownNull && unknown != EnumUnknownError {
+		return nil, errors.Errorf("enum_unknown should be raw, null or error, actual %s", unknown)
+	}
+
+	table := &enumTable{
+		name:    ref,
+		numeric: isNumeric(typ),
+		unknown: unknown,
+		labels:  make(map[string]string, len(structure.Enum)),
+		values:  make(map[string]any, len(structure.Enum)),
+	}
+	for _, item := range structure.Enum {
+		key, err := table.key(item.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "enum '%s'", ref)
+		}
+		if _, ok := table.labels[key]; ok {
+			return nil, errors.Errorf("enum '%s': duplicate value %v", ref, item.Value)
+		}
+		if _, ok := table.values[item.Label]; ok {
+			return nil, errors.Errorf("enum '%s': duplicate label %s", ref, item.Label)
+		}
+		table.labels[key] = item.Label
+		table.values[item.Label] = item.Value
+	}
+	return table, nil
+}
+
+// key 规范化的值
+func (this *enumTable) key(val any) (string, error) {
+	if !this.numeric {
+		return strings.ToUpper(utils.ToString(val)), nil
+	}
+	if s, ok := val.(string); ok {
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return strconv.FormatInt(i, 10), nil
+		}
+		if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+			return strconv.FormatUint(u, 10), nil
+		}
+		return "", errors.Errorf("enum value '%s' should be a number", s)
+	}
+	if u, ok := val.(uint64); ok {
+		return strconv.FormatUint(u, 10), nil
+	}
+	if f, ok := val.(float64); ok && f != float64(int64(f)) {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+	i, ok := utils.ToInt64(val)
+	if !ok {
+		return "", errors.Errorf("enum value %v should be a number", val)
+	}
+	return strconv.FormatInt(i, 10), nil
+}
+
+// decode 解码的值对应的标签
+func (this *enumTable) decode(val any) (any, error) {
+	key, err := this.key(val)
+	if err != nil {
+		return nil, err
+	}
+	if label, ok := this.labels[key]; ok {
+		return label, nil
+	}
+	switch this.unknown {
+	case EnumUnknownError:
+		return nil, errors.Errorf("value %v not found in enum '%s'", val, this.name)
+	case EnumUnknownNull:
+		return nil, nil
+	}
+	return fmt.Sprint(val), nil
+}
+
+// setField 字段保存原始值, 使 check/switch/表达式可以直接使用, 标签保存在 name + "_label" 字段中
+func (this *enumTable) setField(ctx *core.Context, name string, val any) error {
+	label, err := this.decode(val)
+	if err != nil {
+		return errors.Wrapf(err, "field %s", name)
+	}
+	if ctx.IsScopeValue(name) {
+		return errors.Errorf("field %s: enum label can not be stored beside an array element, wrap it in a field", name)
+	}
+	ctx.SetField(name, val)
+	ctx.SetField(name+EnumLabelSuffix, label)
+	return nil
+}
+
+// encode 编码时接受标签, 值或者解码结果的格式, 返回要写入的值
+func (this *enumTable) encode(val any) (any, error) {
+	if m, ok := val.(map[string]any); ok {
+		if v, ok := m[EnumValueKey]; ok && v != nil {
+			val = v
+		} else {
+			val = m[EnumLabelKey]
+		}
+	}
+	if s, ok := val.(string); ok {
+		if v, ok := this.values[s]; ok {
+			return v, nil
+		}
+	}
+	key, err := this.key(val)
+	if err == nil {
+		if _, ok := this.labels[key]; ok {
+			return val, nil
+		}
+	}
+	if this.unknown == EnumUnknownError || err != nil {
+		return nil, errors.Errorf("value or label %v not found in enum '%s'", val, this.name)
+	}
+	return val, nil
+}
@@ -0,0 +1,163 @@
+package node
+
+import (
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+	"github.com/vuuvv/vpacket/utils"
+)
+
+const (
+	FlagsValueKey = "value" // 解码结果中的原始值
+	FlagsListKey  = "flags" // 解码结果中置位的标志名, 按位从低到高
+)
+
+// FlagsNode 标志位, 把 1-8 字节的掩码解码为 {"value": 原始值, "flags": [置位的标志名]}
+// 标志位通过 ref 引用 data_structures 中的 flags, 或者使用内联的 flags 定义, 没有定义的位只保留在 value 中
+// 编码时接受标志名列表, 数字或者解码结果的格式(有 flags 时使用 flags, 没有定义的位取自 value, 否则使用 value)
+type FlagsNode struct {
+	core.BaseNode
+	core.BaseEncodable
+	Size  int
+	Flags []*core.Flag
+	bits  map[string]int
+}
+
+func (n *FlagsNode) Compile(yf *core.YamlField, structures core.DataStructures) error {
+	_ = n.BaseNode.Compile(yf, structures)
+	if err := n.BaseEncodable.Compile(yf, structures); err != nil {
+		return errors.WithStack(err)
+	}
+	n.Size = yf.Size
+	if n.Size < 1 || n.Size > 8 {
+		return errors.Errorf("flags %s: size should between 1 and 8, actual %d", n.Name, n.Size)
+	}
+
+	n.Flags = yf.Flags
+	if yf.Ref != "" {
+		structure, ok := structures[yf.Ref]
+		if !ok {
+			return errors.Errorf("flags %s: ref '%s' not found", n.Name, yf.Ref)
+		}
+		n.Flags = structure.Flags
+	}
+	if len(n.Flags) == 0 {
+		return errors.Errorf("flags %s: requires either 'ref' or 'flags'", n.Name)
+	}
+
+	n.bits = make(map[string]int, len(n.Flags))
+	for _, flag := range n.Flags {
+		if flag.Bit < 0 || flag.Bit >= n.Size*8 {
+			return errors.Errorf("flags %s: bit of '%s' should between 0 and %d, actual %d", n.Name, flag.Name, n.Size*8-1, flag.Bit)
+		}
+		if _, ok := n.bits[flag.Name]; ok || flag.Name == "" {
+			return errors.Errorf("flags %s: flag name '%s' should be unique and not empty", n.Name, flag.Name)
+		}
+		n.bits[flag.Name] = flag.Bit
+	}
+	return nil
+}
+
+func (n *FlagsNode) Decode(ctx *core.Context) error {
+	bs, err := ctx.ReadBytes(n.Size)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	raw, err := utils.ConvertBytesToInt(bs, n.ByteOrder)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	flags := make([]any, 0)
+	for bit := 0; bit < n.Size*8; bit++ {
+		if raw&(1<<bit) == 0 {
+			continue
+		}
+		for _, flag := range n.Flags {
+			if flag.Bit == bit {
+				flags = append(flags, flag.Name)
+			}
+		}
+	}
+	ctx.SetField(n.Name, map[string]any{FlagsValueKey: raw, FlagsListKey: flags})
+	return nil
+}
+
+func (n *FlagsNode) Encode(ctx *core.Context) error {
+	if ctx.Round > n.GetRound() { // 编译的轮次大于节点轮次，跳过
+		return nil
+	}
+	if ctx.Round < n.GetRound() { // 编译的轮次小于节点轮次，写入占位符
+		return ctx.WritePlaceholder(n.Size)
+	}
+
+	val, ok := ctx.GetLocalField(n.Name)
+	if !ok {
+		if err := ctx.FieldMissing(n.Name); err != nil {
+			return err
+		}
+		return ctx.WritePlaceholder(n.Size)
+	}
+	var raw uint64
+	if m, ok := val.(map[string]any); ok {
+		if flags, ok := m[FlagsListKey]; ok {
+			val = flags
+			// 没有定义的位只保留在 value 中, 重新编码解码结果时不能丢失
+			if v, ok := m[FlagsValueKey]; ok {
+				u, err := utils.UintToBits(v, n.Size*8)
+				if err != nil {
+					return errors.Wrapf(err, "flags %s", n.Name)
+				}
+				raw = u &^ n.mask()
+			}
+		} else {
+			val = m[FlagsValueKey]
+		}
+	}
+
+	switch v := val.(type) {
+	case []string:
+		for _, name := range v {
+			if err := n.set(&raw, name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, name := range v {
+			s, ok := name.(string)
+			if !ok {
+				return errors.Errorf("flags %s: flag name should be a string, actual %T", n.Name, name)
+			}
+			if err := n.set(&raw, s); err != nil {
+				return err
+			}
+		}
+	default:
+		var err error
+		if raw, err = utils.UintToBits(val, n.Size*8); err != nil {
+			return errors.Wrapf(err, "flags %s", n.Name)
+		}
+	}
+	return ctx.WriteInt(raw, n.Size, n.ByteOrder)
+}
+
+// mask 定义了标志的位
+func (n *FlagsNode) mask() uint64 {
+	var mask uint64
+	for _, bit := range n.bits {
+		mask |= 1 << bit
+	}
+	return mask
+}
+
+func (n *FlagsNode) set(raw *uint64, name string) error {
+	bit, ok := n.bits[name]
+	if !ok {
+		return errors.Errorf("flags %s: unknown flag '%s'", n.Name, name)
+	}
+	*raw |= 1 << bit
+	return nil
+}
+
+func registerFlags() {
+	core.RegisterNodeCompilerFactory[FlagsNode](core.NodeTypeFlags, false)
+}
@@ -7,4 +7,9 @@ func Register() {
 	registerSwitch()
 	registerStruct()
 	registerArray()
+	registerTunnel()
+	registerDatetime()
+	registerBitfield()
+	registerFlags()
+	registerTlv()
 }
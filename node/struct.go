@@ -5,25 +5,28 @@ import (
 	"github.com/vuuvv/vpacket/core"
 )
 
+// StructNode 结构体, 使用 ref 引用 data_structures 中的结构, 或者使用内联的 fields
+// 默认子字段直接写在当前作用域, 需要使用完整的字段名(如 data.code), 和以前的行为相同
+// 设置 namespace: true 时打开以 name 为前缀的命名空间, 子字段名相对于结构体名, 如结构体 data 的子字段 code 为 data.code,
+// 同一个结构可以在不同的字段下复用; 兼容以结构体名为前缀的写法, 子字段的表达式可以使用 self(结构体自身) 和 parent(上一层的作用域或根字段)
+// 迁移: 在结构体上加上 namespace: true, 子字段名去掉结构体名的前缀, 表达式中的 fields.<结构体名>.x 可以改为 self.x
 type StructNode struct {
 	core.BaseNode
-	Ref    string
-	Fields []core.Node
+	Ref       string
+	Fields    []core.Node
+	Namespace bool
 }
 
 func (n *StructNode) Compile(yf *core.YamlField, structures core.DataStructures) (err error) {
 	_ = n.BaseNode.Compile(yf, structures)
 	n.Ref = yf.Ref
+	n.Namespace = yf.Namespace
 
-	if n.Ref == "" {
-		return errors.Errorf("struct node ref should not be empty")
+	if n.Namespace && n.Name == "" {
+		return errors.Errorf("struct node with namespace should have a name")
 	}
 
-	structure, ok := structures[n.Ref]
-	if !ok {
-		return errors.Errorf("struct ref '%s' not found", n.Ref)
-	}
-	n.Fields, err = core.NodeCompile(structure.Fields, structures)
+	n.Fields, err = core.NodeCompileWithRef(n.Ref, yf.Fields, structures, true)
 	if err != nil {
 		return errors.Wrapf(err, "struct fields compile failed: %s", err.Error())
 	}
@@ -31,10 +34,29 @@ func (n *StructNode) Compile(yf *core.YamlField, structures core.DataStructures)
 }
 
 func (n *StructNode) Decode(ctx *core.Context) error {
+	if !n.Namespace {
+		return core.NodeDecode(ctx, n.Fields...)
+	}
+	// 同名的结构体(如多个 data)合并到同一个 map 中
+	fields, _ := ctx.GetLocalField(n.Name)
+	dict, ok := fields.(map[string]any)
+	if !ok {
+		dict = make(map[string]any)
+		ctx.SetField(n.Name, dict)
+	}
+	ctx.PushStructScope(n.Name, dict)
+	defer ctx.PopScope()
 	return core.NodeDecode(ctx, n.Fields...)
 }
 
 func (n *StructNode) Encode(ctx *core.Context) error {
+	if !n.Namespace {
+		return core.NodeEncode(ctx, n.Fields...)
+	}
+	fields, _ := ctx.GetLocalField(n.Name)
+	dict, _ := fields.(map[string]any)
+	ctx.PushStructScope(n.Name, dict)
+	defer ctx.PopScope()
 	return core.NodeEncode(ctx, n.Fields...)
 }
 
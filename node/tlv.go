@@ -0,0 +1,287 @@
+package node
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+	"github.com/vuuvv/vpacket/utils"
+	"slices"
+	"strconv"
+)
+
+const (
+	TlvTagKey   = "tag"   // 解码结果中的 tag
+	TlvNameKey  = "name"  // 解码结果中 tag 的名称, 未知的 tag 为空
+	TlvValueKey = "value" // 解码结果中的值, 未知的 tag 为 hex
+)
+
+// TlvNode tag-length-value 列表, 循环读取直到用完 size/size_expr 指定的字节(默认为剩余的所有数据)
+// 每个 value 按 tag 对应的 case(ref 或 fields) 单独解码, 没有对应的 case 时使用 default, 都没有时保留为 hex
+// case 中可以再使用 tlv 节点解码嵌套的 TLV
+// 解码结果为 [{"tag": tag, "name": 名称, "value": 值}]
+// 编码时接受同样格式的列表(可以只有 tag 或 name), 或者 {名称或 tag: 值} 的 map, map 按 case 的声明顺序编码
+type TlvNode struct {
+	core.BaseNode
+	core.BaseEncodable
+	TagSize     int
+	LengthSize  int
+	Size        int
+	SizeExpr    *core.CelEvaluator
+	Cases       []*tlvCase
+	DefaultCase []core.Node
+	tags        map[uint64]*tlvCase
+	names       map[string]*tlvCase
+}
+
+type tlvCase struct {
+	Tag   uint64
+	Name  string
+	Nodes []core.Node
+	Round int
+}
+
+func (n *TlvNode) Compile(yf *core.YamlField, structures core.DataStructures) (err error) {
+	_ = n.BaseNode.Compile(yf, structures)
+	if err = n.BaseEncodable.Compile(yf, structures); err != nil {
+		return errors.WithStack(err)
+	}
+	if n.Round != 0 {
+		return errors.Errorf("tlv %s should not set round", n.Name)
+	}
+	n.TagSize, n.LengthSize = yf.TagSize, yf.LengthSize
+	if n.TagSize == 0 {
+		n.TagSize = 1
+	}
+	if n.LengthSize == 0 {
+		n.LengthSize = 1
+	}
+	if n.TagSize < 1 || n.TagSize > 8 || n.LengthSize < 1 || n.LengthSize > 8 {
+		return errors.Errorf("tlv %s: tag_size and length_size should between 1 and 8", n.Name)
+	}
+
+	n.Size = yf.Size
+	if yf.SizeExpr != "" {
+		if n.SizeExpr, err = core.CompileExpression(yf.SizeExpr); err != nil {
+			return errors.Wrapf(err, "Compile 'size_expr' of field %s: %s", n.Name, err.Error())
+		}
+	}
+
+	n.tags = make(map[uint64]*tlvCase)
+	n.names = make(map[string]*tlvCase)
+	for _, c := range yf.Cases {
+		tag, ok := utils.ToUint64(c.Value)
+		if s, isString := c.Value.(string); isString {
+			tag, err = strconv.ParseUint(s, 0, 64)
+			ok = err == nil
+		}
+		if !ok {
+			return errors.Errorf("tlv %s: case value %v should be a number", n.Name, c.Value)
+		}
+		nodes, err := core.NodeCompileWithRef(c.Ref, c.Fields, structures, true)
+		if err != nil {
+			return errors.Wrapf(err, "tlv %s: case for tag %v compile failure: %s", n.Name, c.Value, err.Error())
+		}
+		tc := &tlvCase{Tag: tag, Name: c.Name, Nodes: nodes, Round: core.NodeMaxRound(nodes)}
+		if _, ok := n.tags[tag]; ok {
+			return errors.Errorf("tlv %s: duplicate tag %v", n.Name, c.Value)
+		}
+		n.tags[tag] = tc
+		if c.Name != "" {
+			if _, ok := n.names[c.Name]; ok {
+				return errors.Errorf("tlv %s: duplicate name %s", n.Name, c.Name)
+			}
+			n.names[c.Name] = tc
+		}
+		n.Cases = append(n.Cases, tc)
+	}
+
+	if yf.DefaultRef != "" || len(yf.DefaultFields) > 0 {
+		n.DefaultCase, err = core.NodeCompileWithRef(yf.DefaultRef, yf.DefaultFields, structures, false)
+		if err != nil {
+			return errors.Wrapf(err, "tlv %s: default case compile failure: %s", n.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+func (n *TlvNode) Decode(ctx *core.Context) error {
+	size := -1
+	if n.Size != 0 || n.SizeExpr != nil {
+		var err error
+		if size, err = ctx.GetSize(n.Size, n.SizeExpr); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	data, err := ctx.ReadBytes(size)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	items := make([]any, 0)
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < n.TagSize+n.LengthSize {
+			return errors.Errorf("tlv %s: truncated item header at %d", n.Name, pos)
+		}
+		tag, _ := utils.ConvertBytesToInt(data[pos:pos+n.TagSize], n.ByteOrder)
+		pos += n.TagSize
+		length, _ := utils.ConvertBytesToInt(data[pos:pos+n.LengthSize], n.ByteOrder)
+		pos += n.LengthSize
+		if length > uint64(len(data)-pos) {
+			return errors.Errorf("tlv %s: tag %d length %d exceeds remaining %d bytes", n.Name, tag, length, len(data)-pos)
+		}
+		value := data[pos : pos+int(length)]
+		pos += int(length)
+
+		item := map[string]any{TlvTagKey: tag, TlvNameKey: ""}
+		nodes := n.DefaultCase
+		if c, ok := n.tags[tag]; ok {
+			item[TlvNameKey], nodes = c.Name, c.Nodes
+		}
+		if nodes == nil {
+			item[TlvValueKey] = fmt.Sprintf("%02X", value)
+		} else {
+			inner := ctx.Fork(value)
+			inner.Vars["packetLen"] = len(value)
+			if err = core.NodeDecode(inner, nodes...); err != nil {
+				return errors.Wrapf(err, "tlv %s: decode tag %d failed", n.Name, tag)
+			}
+			item[TlvValueKey] = inner.Fields
+		}
+		items = append(items, item)
+	}
+	ctx.SetField(n.Name, items)
+	return nil
+}
+
+func (n *TlvNode) Encode(ctx *core.Context) error {
+	if ctx.Round > 0 {
+		return nil
+	}
+	val, ok := ctx.GetLocalField(n.Name)
+	if !ok {
+		return ctx.FieldMissing(n.Name)
+	}
+
+	var items []map[string]any
+	switch v := val.(type) {
+	case []any:
+		for _, item := range v {
+			m, ok := item.(map[string]any)
+			if !ok {
+				return errors.Errorf("tlv %s: item should be a map, actual %T", n.Name, item)
+			}
+			items = append(items, m)
+		}
+	case []map[string]any:
+		items = v
+	case map[string]any:
+		var err error
+		if items, err = n.itemsFromMap(v); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("tlv %s should be a list or a map, actual %T", n.Name, val)
+	}
+
+	var bs []byte
+	for _, item := range items {
+		encoded, err := n.encodeItem(ctx, item)
+		if err != nil {
+			return err
+		}
+		bs = append(bs, encoded...)
+	}
+	if n.SizeExpr == nil && n.Size > 0 && len(bs) != n.Size {
+		return errors.Errorf("tlv %s size should be %d, actual %d", n.Name, n.Size, len(bs))
+	}
+	return ctx.WriteBytes(bs)
+}
+
+// itemsFromMap map 的键为 case 的名称或 tag, 先按 case 的声明顺序, 其它的 tag 从小到大
+func (n *TlvNode) itemsFromMap(m map[string]any) ([]map[string]any, error) {
+	var items []map[string]any
+	used := make(map[string]bool, len(m))
+	for _, c := range n.Cases {
+		for _, key := range []string{c.Name, strconv.FormatUint(c.Tag, 10)} {
+			if value, ok := m[key]; ok && key != "" && !used[key] {
+				items = append(items, map[string]any{TlvTagKey: c.Tag, TlvValueKey: value})
+				used[key] = true
+			}
+		}
+	}
+
+	var tags []uint64
+	rest := make(map[uint64]any)
+	for key, value := range m {
+		if used[key] {
+			continue
+		}
+		tag, err := strconv.ParseUint(key, 0, 64)
+		if err != nil {
+			return nil, errors.Errorf("tlv %s: unknown tag name '%s'", n.Name, key)
+		}
+		tags = append(tags, tag)
+		rest[tag] = value
+	}
+	slices.Sort(tags)
+	for _, tag := range tags {
+		items = append(items, map[string]any{TlvTagKey: tag, TlvValueKey: rest[tag]})
+	}
+	return items, nil
+}
+
+func (n *TlvNode) encodeItem(ctx *core.Context, item map[string]any) ([]byte, error) {
+	var c *tlvCase
+	var tag uint64
+	if v, ok := item[TlvTagKey]; ok {
+		var err error
+		if tag, err = utils.UintToBits(v, n.TagSize*8); err != nil {
+			return nil, errors.Wrapf(err, "tlv %s: invalid tag", n.Name)
+		}
+		c = n.tags[tag]
+	} else {
+		name, _ := item[TlvNameKey].(string)
+		if c = n.names[name]; c == nil {
+			return nil, errors.Errorf("tlv %s: unknown tag name '%s'", n.Name, name)
+		}
+		tag = c.Tag
+	}
+
+	var value []byte
+	switch v := item[TlvValueKey].(type) {
+	case string:
+		var err error
+		if value, err = hex.DecodeString(v); err != nil {
+			return nil, errors.Wrapf(err, "tlv %s: value of tag %d is not a valid hex", n.Name, tag)
+		}
+	case map[string]any:
+		nodes, round := n.DefaultCase, core.NodeMaxRound(n.DefaultCase)
+		if c != nil {
+			nodes, round = c.Nodes, c.Round
+		}
+		if nodes == nil {
+			return nil, errors.Errorf("tlv %s: tag %d has no definition, value should be hex", n.Name, tag)
+		}
+		inner := ctx.Fork(nil)
+		inner.Fields = v
+		var err error
+		if value, err = core.NodeEncodeRounds(inner, round, nodes...); err != nil {
+			return nil, errors.Wrapf(err, "tlv %s: encode tag %d failed", n.Name, tag)
+		}
+	default:
+		return nil, errors.Errorf("tlv %s: value of tag %d should be a map or hex, actual %T", n.Name, tag, v)
+	}
+
+	length, err := utils.UintToBits(len(value), n.LengthSize*8)
+	if err != nil {
+		return nil, errors.Wrapf(err, "tlv %s: value of tag %d too long", n.Name, tag)
+	}
+	ret := utils.Uint64ToBytes(tag, n.TagSize, n.ByteOrder)
+	ret = append(ret, utils.Uint64ToBytes(length, n.LengthSize, n.ByteOrder)...)
+	return append(ret, value...), nil
+}
+
+func registerTlv() {
+	core.RegisterNodeCompilerFactory[TlvNode](core.NodeTypeTlv, false)
+}
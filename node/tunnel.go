@@ -0,0 +1,195 @@
+package node
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/vpacket/core"
+)
+
+const (
+	TunnelProtocolKey = "protocol" // 解码结果中内层协议或结构的名称
+	TunnelDataKey     = "data"     // 解码结果中内层数据
+	TunnelRestKey     = "rest"     // 解码结果中内层协议或结构没有使用的数据, hex 格式, 没有剩余数据时不设置
+)
+
+// TunnelNode 把一段数据交给其它协议或结构解码, 如网关报文中透传的设备报文
+// protocol 为 scheme 中的协议名, 先按该协议的分包规则取出报文再解码, 编码时同样加上分包规则
+// 只在 tunnel 中出现的协议应该放在 tunnel_protocols 中, 放在 protocols 中的协议也会参与数据流的分包
+// 没有 protocol 时使用 ref/fields 定义的结构解码
+// size/size_expr 都未设置时使用剩余的所有数据
+// 解码结果为 {"protocol": 协议或结构名, "data": 内层字段}, 内层没有用完的数据放在 "rest" 中, 编码时追加在内层数据之后
+type TunnelNode struct {
+	core.BaseNode
+	Protocol string
+	Ref      string
+	Fields   []core.Node
+	Size     int
+	SizeExpr *core.CelEvaluator
+}
+
+func (n *TunnelNode) Compile(yf *core.YamlField, structures core.DataStructures) (err error) {
+	_ = n.BaseNode.Compile(yf, structures)
+	n.Protocol = yf.Protocol
+	n.Ref = yf.Ref
+	n.Size = yf.Size
+
+	if n.Round != 0 {
+		return errors.Errorf("tunnel field %s should not set round", n.Name)
+	}
+
+	if yf.SizeExpr != "" {
+		expr, err := core.CompileExpression(yf.SizeExpr)
+		if err != nil {
+			return errors.Wrapf(err, "Compile 'size_expr' of field %s: %s", n.Name, err.Error())
+		}
+		n.SizeExpr = expr
+	}
+
+	if n.Protocol != "" {
+		if n.Ref != "" || len(yf.Fields) > 0 {
+			return errors.Errorf("tunnel field %s: protocol and ref/fields should not both be set", n.Name)
+		}
+		return nil
+	}
+
+	n.Fields, err = core.NodeCompileWithRef(yf.Ref, yf.Fields, structures, true)
+	if err != nil {
+		return errors.Wrapf(err, "tunnel field %s compile failed: %s", n.Name, err.Error())
+	}
+	return nil
+}
+
+func (n *TunnelNode) Decode(ctx *core.Context) error {
+	size := -1
+	if n.Size != 0 || n.SizeExpr != nil {
+		var err error
+		size, err = ctx.GetSize(n.Size, n.SizeExpr)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	data, err := ctx.ReadBytes(size)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if n.Protocol == "" {
+		inner := ctx.Fork(data)
+		inner.Vars["packetLen"] = len(data)
+		if err = core.NodeDecode(inner, n.Fields...); err != nil {
+			return errors.Wrapf(err, "tunnel decode '%s' failed", n.Ref)
+		}
+		ctx.SetField(n.Name, n.result(n.Ref, inner.Fields, data[inner.BytePos:]))
+		return nil
+	}
+
+	protocol, err := n.protocol(ctx)
+	if err != nil {
+		return err
+	}
+	res := protocol.ParsedFramingRule.Split(data)
+	switch {
+	case res.Error != nil:
+		return errors.Wrapf(res.Error, "tunnel protocol '%s' framing failed", n.Protocol)
+	case res.Abandoned:
+		return errors.Errorf("tunnel protocol '%s' framing failed: invalid packet %X", n.Protocol, data)
+	case res.Advance == 0 && res.Token == nil:
+		return errors.Errorf("tunnel protocol '%s' framing failed: incomplete packet %X", n.Protocol, data)
+	}
+	fields, err := protocol.Decode(res.Token)
+	if err != nil {
+		return errors.Wrapf(err, "tunnel protocol '%s' decode failed", n.Protocol)
+	}
+	ctx.SetField(n.Name, n.result(n.Protocol, fields, data[res.Advance:]))
+	return nil
+}
+
+func (n *TunnelNode) result(name string, fields any, rest []byte) map[string]any {
+	ret := map[string]any{TunnelProtocolKey: name, TunnelDataKey: fields}
+	if len(rest) > 0 {
+		ret[TunnelRestKey] = fmt.Sprintf("%02X", rest)
+	}
+	return ret
+}
+
+// Encode 内层数据只在第一轮编码, 值可以是解码结果的格式, 也可以直接是内层字段, 或者是已经编码好的 hex
+func (n *TunnelNode) Encode(ctx *core.Context) error {
+	if ctx.Round > 0 {
+		return nil
+	}
+	val, ok := ctx.GetLocalField(n.Name)
+	if !ok {
+		return errors.Errorf("tunnel field %s not found", n.Name)
+	}
+
+	var bs []byte
+	var err error
+	switch v := val.(type) {
+	case string:
+		bs, err = hex.DecodeString(v)
+		if err != nil {
+			return errors.Wrapf(err, "tunnel field %s is not a valid hex", n.Name)
+		}
+	case map[string]any:
+		fields := v
+		if data, ok := v[TunnelDataKey].(map[string]any); ok {
+			fields = data
+		}
+		bs, err = n.encode(ctx, fields)
+		if err != nil {
+			return err
+		}
+		if rest, ok := v[TunnelRestKey].(string); ok {
+			restBytes, err := hex.DecodeString(rest)
+			if err != nil {
+				return errors.Wrapf(err, "tunnel field %s rest is not a valid hex", n.Name)
+			}
+			bs = append(bs, restBytes...)
+		}
+	default:
+		return errors.Errorf("tunnel field %s should be a map or hex, actual %T", n.Name, val)
+	}
+
+	if n.SizeExpr == nil && n.Size > 0 && len(bs) != n.Size {
+		return errors.Errorf("tunnel field %s size should be %d, actual %d", n.Name, n.Size, len(bs))
+	}
+	return ctx.WriteBytes(bs)
+}
+
+func (n *TunnelNode) encode(ctx *core.Context, fields map[string]any) ([]byte, error) {
+	inner := ctx.Fork(nil)
+	inner.Fields = fields
+	if n.Protocol == "" {
+		bs, err := core.NodeEncodeRounds(inner, core.NodeMaxRound(n.Fields), n.Fields...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tunnel encode '%s' failed", n.Ref)
+		}
+		return bs, nil
+	}
+
+	protocol, err := n.protocol(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := protocol.Encode(inner)
+	if err != nil {
+		return nil, errors.Wrapf(err, "tunnel protocol '%s' encode failed", n.Protocol)
+	}
+	return bs, nil
+}
+
+func (n *TunnelNode) protocol(ctx *core.Context) (*core.Protocol, error) {
+	if ctx.Scheme == nil {
+		return nil, errors.Errorf("tunnel protocol '%s': scheme not available", n.Protocol)
+	}
+	protocol := ctx.Scheme.GetTunnelProtocol(n.Protocol)
+	if protocol == nil {
+		return nil, errors.Errorf("tunnel protocol '%s' not found", n.Protocol)
+	}
+	return protocol, nil
+}
+
+func registerTunnel() {
+	core.RegisterNodeCompilerFactory[TunnelNode](core.NodeTypeTunnel, false)
+}
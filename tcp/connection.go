@@ -25,6 +25,9 @@ type DeviceConnection struct {
 	sn             string // 实际的连接设备，可能是设备,dtu,网关等
 	deviceType     string
 	subDevices     []string // 子设备的key(一般是序列号),子设备可以查询服务器获取,或者子设备自己发送心跳(哪种形式应该由服务器进行配置)
+	protocol       string   // 连接上最后一次收到的报文使用的协议, 作为发送命令时的默认协议
+	protocols      sync.Map // 设备序列号 -> 该设备最后一次发送的报文使用的协议
+	codec          *core.Codec
 }
 
 func NewDeviceConnection(server *Server, conn net.Conn) *DeviceConnection {
@@ -136,11 +139,31 @@ func (this *DeviceConnection) Scan(protocol *core.Scheme) error {
 	/// 启动一个 Goroutine 来监听 Context 取消事件
 	go this.checkCancel()
 
-	return core.NewCodec().Config(protocol).Stream(this.conn).Scan(this.Handle)
+	codec := core.NewCodec().Config(protocol).Stream(this.conn)
+	this.mu.Lock()
+	this.codec = codec
+	this.mu.Unlock()
+	return codec.Scan(this.Handle)
 }
 
+// Stats 连接的扫描统计
+func (this *DeviceConnection) Stats() core.CodecStats {
+	this.mu.Lock()
+	codec := this.codec
+	this.mu.Unlock()
+	if codec == nil {
+		return core.CodecStats{}
+	}
+	return codec.Stats()
+}
+
+// Encode 编码命令, 命令中没有指定协议时使用连接上最后一次收到的报文的协议
 func (this *DeviceConnection) Encode(data map[string]any) ([]byte, error) {
-	return core.NewCodec().Config(this.server.scheme).Encode(data)
+	return this.EncodeWith(this.defaultProtocol(data, ""), data)
+}
+
+func (this *DeviceConnection) EncodeWith(protocolName string, data map[string]any) ([]byte, error) {
+	return core.NewCodec().Config(this.server.scheme).EncodeWith(protocolName, data)
 }
 
 func (this *DeviceConnection) SendCommand(cmd map[string]any) error {
@@ -152,11 +175,57 @@ func (this *DeviceConnection) SendCommand(cmd map[string]any) error {
 	return err
 }
 
+// SendCommandTo 向连接上的设备或子设备发送命令, 命令中没有指定协议时使用该设备最后一次发送的报文的协议
+func (this *DeviceConnection) SendCommandTo(sn string, cmd map[string]any) error {
+	bs, err := this.EncodeWith(this.defaultProtocol(cmd, sn), cmd)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = this.Write(bs)
+	return err
+}
+
+// Protocol 获取设备最后一次发送的报文使用的协议, 设备没有发送过报文时使用连接上最后一次收到的报文的协议
+func (this *DeviceConnection) Protocol(sn string) string {
+	if sn != "" {
+		if name, ok := this.protocols.Load(sn); ok {
+			return name.(string)
+		}
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.protocol
+}
+
+// defaultProtocol 命令中指定了协议时返回空, 由编码器使用命令中的协议
+func (this *DeviceConnection) defaultProtocol(cmd map[string]any, sn string) string {
+	if _, ok := cmd[core.EncodeProtocolKey]; ok {
+		return ""
+	}
+	return this.Protocol(sn)
+}
+
+// rememberProtocol 记录设备使用的协议, 丢弃或解析失败的报文不记录
+func (this *DeviceConnection) rememberProtocol(result *core.ScanResult) {
+	if result == nil || result.Protocol == nil || result.Abaddon || result.ScanError != nil {
+		return
+	}
+	this.mu.Lock()
+	this.protocol = result.Protocol.Name
+	this.mu.Unlock()
+
+	sn, _, _ := this.getConnectionDevice(result)
+	if sn != "" {
+		this.protocols.Store(sn, result.Protocol.Name)
+	}
+}
+
 func (this *DeviceConnection) Handle(result *core.ScanResult) error {
 	log.Info("接收报文", this.zapFields(zap.String("data", utils.Bytes2Hex(result.Packet)))...)
 
 	/// 检查是否是连接设备
 	this.setupDeviceSn(result)
+	this.rememberProtocol(result)
 	this.UpdateActiveTime()
 	if this.server.messageHandle != nil {
 		err := this.server.messageHandle(result)
@@ -253,7 +322,7 @@ func (this *DeviceConnection) Heartbeat(duration int, discoveryFunc DeviceDiscov
 			heartbeatCommand["sn"] = sn
 		}
 
-		data, err := this.Encode(heartbeatCommand)
+		data, err := this.EncodeWith(this.defaultProtocol(heartbeatCommand, sn), heartbeatCommand)
 		if err != nil {
 			log.Warn(errors.Wrapf(err, "编码子设备心跳命令失败: %s, %s", sn, err.Error()), this.zapFields()...)
 			continue
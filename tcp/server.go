@@ -184,6 +184,7 @@ func (s *Server) RemoveDevice(conn *DeviceConnection) {
 
 func (s *Server) RemoveDeviceSn(conn *DeviceConnection, snList ...string) {
 	for _, sn := range snList {
+		conn.protocols.Delete(sn)
 		if key, ok := s.devices.Load(sn); ok {
 			if key == conn.key {
 				s.devices.Delete(sn)
@@ -243,7 +244,7 @@ func (s *Server) SendCommand(sn string, data map[string]any) error {
 	if conn == nil {
 		return errors.Errorf("device '%s' not found", sn)
 	}
-	return conn.SendCommand(data)
+	return conn.SendCommandTo(sn, data)
 }
 
 // heartbeatMonitor 心跳监控
@@ -0,0 +1,118 @@
+package utils
+
+// AhoCorasick 多模式匹配自动机, 用于在数据流中快速查找报文头标记
+type AhoCorasick struct {
+	nodes  []acNode
+	maxLen int
+}
+
+type acNode struct {
+	children map[byte]int32 // 字典树的子节点
+	delta    [256]int32     // 自动机的状态转移
+	fail     int32
+	depth    int
+	patterns []int // 在该节点结束的模式, 按添加顺序
+	maxOut   int   // 在该节点(包括失败链接)结束的最长模式长度, 没有则为 0
+}
+
+// NewAhoCorasick 创建自动机, 模式的下标即模式的 id, 空模式会被忽略
+func NewAhoCorasick(patterns [][]byte) *AhoCorasick {
+	ac := &AhoCorasick{nodes: []acNode{{children: map[byte]int32{}}}}
+	for id, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+		state := int32(0)
+		for _, b := range pattern {
+			next, ok := ac.nodes[state].children[b]
+			if !ok {
+				next = int32(len(ac.nodes))
+				ac.nodes = append(ac.nodes, acNode{children: map[byte]int32{}, depth: ac.nodes[state].depth + 1})
+				ac.nodes[state].children[b] = next
+			}
+			state = next
+		}
+		ac.nodes[state].patterns = append(ac.nodes[state].patterns, id)
+		ac.maxLen = max(ac.maxLen, len(pattern))
+	}
+	ac.build()
+	return ac
+}
+
+// build 按广度优先计算失败链接, 并把字典树转换为完整的状态转移表
+func (this *AhoCorasick) build() {
+	queue := []int32{0}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		node := &this.nodes[state]
+		if len(node.patterns) > 0 {
+			node.maxOut = node.depth
+		} else if state != 0 {
+			node.maxOut = this.nodes[node.fail].maxOut
+		}
+
+		for b := 0; b < 256; b++ {
+			child, ok := node.children[byte(b)]
+			if !ok {
+				if state != 0 {
+					node.delta[b] = this.nodes[node.fail].delta[b]
+				}
+				continue
+			}
+			if state != 0 {
+				this.nodes[child].fail = this.nodes[node.fail].delta[b]
+			}
+			node.delta[b] = child
+			queue = append(queue, child)
+		}
+	}
+}
+
+// Index 查找第一个可能是模式开始的位置: 完整匹配的模式, 或者数据末尾是某个模式的前缀(需要更多数据才能确认), 取位置靠前的一个
+// 没有时返回 -1
+func (this *AhoCorasick) Index(data []byte) int {
+	if this.maxLen == 0 {
+		return -1
+	}
+	best := -1
+	state := int32(0)
+	for j, b := range data {
+		state = this.nodes[state].delta[b]
+		if out := this.nodes[state].maxOut; out > 0 {
+			if start := j - out + 1; best < 0 || start < best {
+				best = start
+			}
+		}
+		// 之后结束的模式不可能在 best 之前开始
+		if best >= 0 && j >= best+this.maxLen-1 {
+			return best
+		}
+	}
+	if depth := this.nodes[state].depth; depth > 0 {
+		if start := len(data) - depth; best < 0 || start < best {
+			best = start
+		}
+	}
+	return best
+}
+
+// MatchPrefix 查找数据开头匹配的模式, 按模式长度从长到短, 长度相同时按添加顺序返回模式 id
+// partial 表示数据已经全部匹配但还可能是更长模式的前缀
+func (this *AhoCorasick) MatchPrefix(data []byte) (ids []int, partial bool) {
+	state := int32(0)
+	for i := 0; ; i++ {
+		node := &this.nodes[state]
+		if len(node.patterns) > 0 {
+			ids = append(node.patterns[:len(node.patterns):len(node.patterns)], ids...)
+		}
+		if i == len(data) {
+			return ids, len(node.children) > 0
+		}
+		next, ok := node.children[data[i]]
+		if !ok {
+			return ids, false
+		}
+		state = next
+	}
+}
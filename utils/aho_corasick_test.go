@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAhoCorasickIndex(t *testing.T) {
+	ac := NewAhoCorasick([][]byte{[]byte("abcd"), []byte("bc"), []byte("xyz")})
+	cases := []struct {
+		data   string
+		expect int
+	}{
+		{"abcd", 0},
+		{"--abcd", 2},
+		{"--abce", 3}, // bc
+		{"------", -1},
+		{"-----x", 5}, // 末尾可能是 xyz 的开始
+		{"---xy", 3},
+		{"--abc", 2}, // abcd 的前缀比 bc 靠前
+		{"-xyz-bc", 1},
+	}
+	for _, c := range cases {
+		if actual := ac.Index([]byte(c.data)); actual != c.expect {
+			t.Fatalf("%s: expect %d, got %d", c.data, c.expect, actual)
+		}
+	}
+}
+
+func TestAhoCorasickMatchPrefix(t *testing.T) {
+	ac := NewAhoCorasick([][]byte{{0x68}, {0x68, 0x16}, {0x68}, {0x7e}})
+
+	ids, partial := ac.MatchPrefix([]byte{0x68, 0x16, 0x01})
+	if !reflect.DeepEqual(ids, []int{1, 0, 2}) || partial {
+		t.Fatalf("expect [1 0 2] false, got %v %v", ids, partial)
+	}
+
+	ids, partial = ac.MatchPrefix([]byte{0x68})
+	if !reflect.DeepEqual(ids, []int{0, 2}) || !partial {
+		t.Fatalf("expect [0 2] true, got %v %v", ids, partial)
+	}
+
+	ids, partial = ac.MatchPrefix([]byte{0x01})
+	if len(ids) != 0 || partial {
+		t.Fatalf("expect no match, got %v %v", ids, partial)
+	}
+}
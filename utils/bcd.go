@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"github.com/vuuvv/errors"
+	"strings"
+)
+
+// DecodeBcd 把 BCD 码转换为数字字符串, 每个字节两位数字, 高半字节在前
+func DecodeBcd(data []byte) (string, error) {
+	buf := make([]byte, 0, len(data)*2)
+	for i, b := range data {
+		hi, lo := b>>4, b&0x0f
+		if hi > 9 || lo > 9 {
+			return "", errors.Errorf("invalid bcd byte %02X at %d", b, i)
+		}
+		buf = append(buf, '0'+hi, '0'+lo)
+	}
+	return string(buf), nil
+}
+
+// EncodeBcd 把数字字符串转换为 size 字节的 BCD 码, 不足时在前面补 0
+func EncodeBcd(digits string, size int) ([]byte, error) {
+	if len(digits) > size*2 {
+		return nil, errors.Errorf("bcd value '%s' overflows %d bytes", digits, size)
+	}
+	digits = strings.Repeat("0", size*2-len(digits)) + digits
+	ret := make([]byte, size)
+	for i := 0; i < size; i++ {
+		hi, lo := digits[2*i], digits[2*i+1]
+		if !isDigit(hi) || !isDigit(lo) {
+			return nil, errors.Errorf("bcd value '%s' should only contain digits", digits)
+		}
+		ret[i] = (hi-'0')<<4 | (lo - '0')
+	}
+	return ret, nil
+}
+
+// DecodePackedBcd 解码带符号的压缩 BCD, 最后一个半字节为符号: C, A, E, F 为正, D, B 为负
+func DecodePackedBcd(data []byte) (digits string, negative bool, err error) {
+	if len(data) == 0 {
+		return "", false, errors.New("packed bcd should not be empty")
+	}
+	last := len(data) - 1
+	switch sign := data[last] & 0x0f; sign {
+	case 0x0a, 0x0c, 0x0e, 0x0f:
+	case 0x0b, 0x0d:
+		negative = true
+	default:
+		return "", false, errors.Errorf("invalid packed bcd sign nibble %X", sign)
+	}
+	digits, err = DecodeBcd(append(data[:last:last], data[last]&0xf0))
+	if err != nil {
+		return "", false, err
+	}
+	// 去掉符号位置补的 0
+	return digits[:len(digits)-1], negative, nil
+}
+
+// EncodePackedBcd 编码带符号的压缩 BCD, 正数的符号为 C, 负数为 D
+func EncodePackedBcd(digits string, negative bool, size int) ([]byte, error) {
+	ret, err := EncodeBcd(digits+"0", size)
+	if err != nil {
+		return nil, errors.Errorf("packed bcd value '%s' overflows %d bytes", digits, size)
+	}
+	if negative {
+		ret[size-1] |= 0x0d
+	} else {
+		ret[size-1] |= 0x0c
+	}
+	return ret, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// Modbus 等协议中 32/64 位的值由多个 16 位寄存器(字)组成, 字的顺序和字内的字节顺序各设备不同
+// 以大端的 ABCD 为基准: ABCD 为大端, DCBA 为小端, CDAB 为字逆序字内大端, BADC 为字顺序字内字节交换
+var (
+	WordSwap binary.ByteOrder = wordOrder{name: "CDAB", swapWords: true} // 字逆序, 字内大端
+	ByteSwap binary.ByteOrder = wordOrder{name: "BADC", swapBytes: true} // 字顺序, 字内字节交换
+)
+
+type wordOrder struct {
+	name      string
+	swapWords bool
+	swapBytes bool
+}
+
+func (this wordOrder) Uint16(b []byte) uint16 {
+	return binary.BigEndian.Uint16(ReorderBytes(b[:2], this))
+}
+
+func (this wordOrder) Uint32(b []byte) uint32 {
+	return binary.BigEndian.Uint32(ReorderBytes(b[:4], this))
+}
+
+func (this wordOrder) Uint64(b []byte) uint64 {
+	return binary.BigEndian.Uint64(ReorderBytes(b[:8], this))
+}
+
+func (this wordOrder) PutUint16(b []byte, v uint16) {
+	binary.BigEndian.PutUint16(b, v)
+	copy(b, ReorderBytes(b[:2], this))
+}
+
+func (this wordOrder) PutUint32(b []byte, v uint32) {
+	binary.BigEndian.PutUint32(b, v)
+	copy(b, ReorderBytes(b[:4], this))
+}
+
+func (this wordOrder) PutUint64(b []byte, v uint64) {
+	binary.BigEndian.PutUint64(b, v)
+	copy(b, ReorderBytes(b[:8], this))
+}
+
+func (this wordOrder) String() string {
+	return this.name
+}
+
+// ReorderBytes 在大端和 order 之间转换字节顺序, 返回新的切片, 转换是对称的, 编码和解码使用同一个函数
+// 字序只对偶数长度的数据有效, 奇数长度时 CDAB 和 BADC 按大端处理
+func ReorderBytes(data []byte, order binary.ByteOrder) []byte {
+	ret := make([]byte, len(data))
+	copy(ret, data)
+	switch o := order.(type) {
+	case wordOrder:
+		if len(ret)%2 != 0 {
+			return ret
+		}
+		if o.swapWords {
+			for i, j := 0, len(ret)-2; i < j; i, j = i+2, j-2 {
+				ret[i], ret[i+1], ret[j], ret[j+1] = ret[j], ret[j+1], ret[i], ret[i+1]
+			}
+		}
+		if o.swapBytes {
+			for i := 0; i < len(ret); i += 2 {
+				ret[i], ret[i+1] = ret[i+1], ret[i]
+			}
+		}
+	default:
+		if order == binary.LittleEndian {
+			for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+				ret[i], ret[j] = ret[j], ret[i]
+			}
+		}
+	}
+	return ret
+}
+
+func GetByteOrder(byteOrderKey string) (byteOrder binary.ByteOrder) {
+	switch strings.ToLower(byteOrderKey) {
+	case "little", "litter", "dcba":
+		return binary.LittleEndian
+	case "cdab":
+		return WordSwap
+	case "badc":
+		return ByteSwap
+	}
+	return binary.BigEndian
+}
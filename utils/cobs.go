@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"github.com/vuuvv/errors"
+)
+
+// CobsEncode 使用 COBS(Consistent Overhead Byte Stuffing) 编码数据, 编码后的数据中不包含 0x00, 不包含结尾的分隔符
+func CobsEncode(data []byte) []byte {
+	ret := make([]byte, 1, len(data)+len(data)/254+2)
+	codeIdx := 0
+	code := byte(1)
+	for i, b := range data {
+		if b == 0 {
+			ret[codeIdx] = code
+			codeIdx = len(ret)
+			ret = append(ret, 0)
+			code = 1
+			continue
+		}
+		ret = append(ret, b)
+		code++
+		if code == 0xFF && i < len(data)-1 {
+			ret[codeIdx] = code
+			codeIdx = len(ret)
+			ret = append(ret, 0)
+			code = 1
+		}
+	}
+	ret[codeIdx] = code
+	return ret
+}
+
+// CobsDecode 解码 COBS 编码的数据, data 不包含结尾的分隔符
+func CobsDecode(data []byte) ([]byte, error) {
+	ret := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		code := int(data[i])
+		if code == 0 {
+			return nil, errors.Errorf("invalid cobs code 00 at offset %d", i)
+		}
+		if i+code > len(data) {
+			return nil, errors.Errorf("invalid cobs block at offset %d: need %d bytes, have %d", i, code, len(data)-i)
+		}
+		for _, b := range data[i+1 : i+code] {
+			if b == 0 {
+				return nil, errors.Errorf("invalid cobs data: unexpected 00 in block at offset %d", i)
+			}
+		}
+		ret = append(ret, data[i+1:i+code]...)
+		i += code
+		if code < 0xFF && i < len(data) {
+			ret = append(ret, 0)
+		}
+	}
+	return ret, nil
+}
@@ -23,14 +23,12 @@ func Bytes2Hex(bytes []byte) string {
 
 func Uint64ToBytes[T constraints.Integer](u T, size int, order binary.ByteOrder) []byte {
 	data := make([]byte, 8)
-	order.PutUint64(data, uint64(u))
+	binary.BigEndian.PutUint64(data, uint64(u))
 
-	switch order {
-	case binary.LittleEndian:
-		return data[:size]
-	default: // 默认情况是大端的
+	if order == nil || order == binary.BigEndian { // 默认情况是大端的
 		return data[8-size:]
 	}
+	return ReorderBytes(data[8-size:], order)
 }
 
 // ParseTValue 核心解析函数：将输入字符串解析为 []byte
@@ -60,7 +58,7 @@ func ParseTValue(inputString string, size int, byteOrder binary.ByteOrder) ([]by
 		binStr := strings.TrimPrefix(strings.TrimPrefix(dataStr, "0b"), "b")
 		u, e := strconv.ParseUint(binStr, 2, 64)
 		if e != nil {
-			return nil, errors.Errorf("invalid binary number 'b''%s': %w", dataStr, e)
+			return nil, errors.Wrapf(e, "invalid binary number 'b''%s'", dataStr)
 		}
 		value = Uint64ToBytes(u, size, byteOrder)
 
@@ -68,14 +66,14 @@ func ParseTValue(inputString string, size int, byteOrder binary.ByteOrder) ([]by
 		octStr := strings.TrimPrefix(dataStr, "0")
 		u, e := strconv.ParseUint(octStr, 8, 64)
 		if e != nil {
-			return nil, errors.Errorf("invalid octal number 'o''%s': %w", dataStr, e)
+			return nil, errors.Wrapf(e, "invalid octal number 'o''%s'", dataStr)
 		}
 		value = Uint64ToBytes(u, size, byteOrder)
 
 	case "d": // 十进制 (Decimal)
 		i, e := strconv.ParseInt(dataStr, 10, 64)
 		if e != nil {
-			return nil, errors.Errorf("invalid decimal number 'd''%s': %w", dataStr, e)
+			return nil, errors.Wrapf(e, "invalid decimal number 'd''%s'", dataStr)
 		}
 		value = Uint64ToBytes(i, size, byteOrder)
 
@@ -89,7 +87,7 @@ func ParseTValue(inputString string, size int, byteOrder binary.ByteOrder) ([]by
 
 		value, err = hex.DecodeString(hexStr)
 		if err != nil {
-			return nil, errors.Errorf("invalid hex string '%s''%s': %w", typeID, dataStr, err)
+			return nil, errors.Wrapf(err, "invalid hex string '%s''%s'", typeID, dataStr)
 		}
 		if size < 0 {
 			return value, nil
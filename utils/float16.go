@@ -0,0 +1,64 @@
+package utils
+
+import "math"
+
+// Float16ToFloat32 IEEE-754 半精度转换为单精度
+func Float16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+
+	switch {
+	case exp == 0x1f: // Inf, NaN
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	case exp == 0 && frac == 0: // ±0
+		return math.Float32frombits(sign)
+	case exp == 0: // 非规格化数
+		v := float32(frac) / (1 << 24)
+		if sign != 0 {
+			return -v
+		}
+		return v
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | frac<<13)
+}
+
+// Float32ToFloat16 单精度转换为 IEEE-754 半精度, 就近舍入到偶数, 超出范围时为 ±Inf
+func Float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	frac := bits & 0x7fffff
+
+	if exp == 0xff { // Inf, NaN
+		if frac != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+
+	e := exp - 127 + 15
+	if e >= 0x1f {
+		return sign | 0x7c00
+	}
+	if e <= 0 {
+		// 非规格化数, 包括隐含的最高位一起右移
+		if e < -10 {
+			return sign
+		}
+		m := frac | 0x800000
+		shift := uint(14 - e)
+		half := uint32(1) << (shift - 1)
+		rounded := m >> shift
+		if rest := m & (1<<shift - 1); rest > half || (rest == half && rounded&1 == 1) {
+			rounded++
+		}
+		return sign | uint16(rounded)
+	}
+
+	rounded := uint32(e)<<10 | frac>>13
+	if rest := frac & 0x1fff; rest > 0x1000 || (rest == 0x1000 && rounded&1 == 1) {
+		rounded++ // 进位可能使指数加 1, 最大时恰好为 Inf
+	}
+	return sign | uint16(rounded)
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrIdle IdleReader 在等待时间内没有读到数据, 不是真正的错误, 可以继续读取
+var ErrIdle = errors.New("idle: no data within interval")
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// IdleReader 在后台协程中读取数据, Read 在 interval 内没有数据时返回 ErrIdle,
+// 使调用者在数据流静默时也能定时检查超时(如不完整的报文, 未收齐的分包)
+// 不再读取时需要调用 Close, 否则后台协程会一直阻塞在发送读到的数据上
+type IdleReader struct {
+	interval  time.Duration
+	ch        chan readResult
+	done      chan struct{}
+	closeOnce sync.Once
+	rest      []byte // 上次读到但还没有返回的数据
+	err       error  // 后台读取的错误, 数据返回完后返回
+}
+
+func NewIdleReader(r io.Reader, interval time.Duration) *IdleReader {
+	reader := &IdleReader{interval: interval, ch: make(chan readResult), done: make(chan struct{})}
+	go func() {
+		buf := make([]byte, startBufSize)
+		for {
+			n, err := r.Read(buf)
+			select {
+			case reader.ch <- readResult{data: bytes.Clone(buf[:n]), err: err}:
+			case <-reader.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return reader
+}
+
+// Close 停止后台协程, 不关闭原始的数据流; 协程正阻塞在原始数据流的 Read 上时, 在 Read 返回后退出
+func (this *IdleReader) Close() error {
+	this.closeOnce.Do(func() {
+		close(this.done)
+	})
+	return nil
+}
+
+func (this *IdleReader) Read(p []byte) (int, error) {
+	if len(this.rest) == 0 && this.err == nil {
+		timer := time.NewTimer(this.interval)
+		defer timer.Stop()
+		select {
+		case res := <-this.ch:
+			this.rest, this.err = res.data, res.err
+		case <-timer.C:
+			return 0, ErrIdle
+		}
+	}
+	if len(this.rest) > 0 {
+		n := copy(p, this.rest)
+		this.rest = this.rest[n:]
+		return n, nil
+	}
+	return 0, this.err
+}
@@ -41,6 +41,7 @@ type Scanner struct {
 	empties      int       // Count of successive empty tokens.
 	scanCalled   bool      // Scan has been called; buffer is in use.
 	done         bool      // Scan has finished.
+	idle         bool      // 上次读取返回了 ErrIdle
 }
 
 // SplitFunc is the signature of the split function used to tokenize the
@@ -195,6 +196,13 @@ func (s *Scanner) Scan() bool {
 				return true
 			}
 		}
+		// 读取空闲时先让 split 检查已有的数据(如超时), 没有 token 时返回一个空结果, 让调用者处理定时任务
+		if s.idle {
+			s.idle = false
+			s.token = nil
+			s.result = nil
+			return true
+		}
 		// We cannot generate a token with what we are holding.
 		// If we've already hit EOF or an I/O error, we are done.
 		if s.err != nil {
@@ -243,6 +251,10 @@ func (s *Scanner) Scan() bool {
 				break
 			}
 			s.end += n
+			if err == ErrIdle && n == 0 {
+				s.idle = true
+				break
+			}
 			if err != nil {
 				s.setErr(err)
 				break
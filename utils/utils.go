@@ -6,6 +6,7 @@ import (
 	"github.com/spf13/cast"
 	"github.com/vuuvv/errors"
 	"gopkg.in/yaml.v3"
+	"math"
 	"reflect"
 	"strconv"
 	"time"
@@ -45,10 +46,13 @@ func S2B(s string) (b []byte) {
 }
 
 func ConvertBytesToInt(data []byte, byteOrder binary.ByteOrder) (uint64, error) {
-	if byteOrder == binary.LittleEndian {
+	switch byteOrder {
+	case binary.LittleEndian:
 		return ConvertBytesToIntLE(data)
+	case binary.BigEndian, nil:
+		return ConvertBytesToIntBE(data)
 	}
-	return ConvertBytesToIntBE(data)
+	return ConvertBytesToIntBE(ReorderBytes(data, byteOrder))
 }
 
 func ToString(val any) string {
@@ -100,6 +104,107 @@ func ToUint64(val any) (uint64, bool) {
 	return 0, false
 }
 
+// ToInt64 转换为有符号整数, 浮点数四舍五入, 超出 int64 范围的 uint64 和浮点数返回 false
+func ToInt64(val any) (int64, bool) {
+	switch v := val.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), uint64(v) <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float32:
+		return roundToInt64(float64(v))
+	case float64:
+		return roundToInt64(v)
+	}
+
+	s := ToString(val)
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return i, true
+	}
+	return 0, false
+}
+
+// roundToInt64 浮点数四舍五入为整数, 避免编码时直接截断小数(如 2315.9 编码为 2315)
+func roundToInt64(f float64) (int64, bool) {
+	r := math.Round(f)
+	if math.IsNaN(r) || r < math.MinInt64 || r >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(r), true
+}
+
+// SignExtend 把 bits 位的补码扩展为 int64, 如 12 位的 0xFFE 为 -2
+func SignExtend(val uint64, bits int) int64 {
+	if bits <= 0 || bits >= 64 {
+		return int64(val)
+	}
+	shift := 64 - bits
+	return int64(val<<shift) >> shift
+}
+
+// IntToBits 把有符号整数转换为 bits 位的补码, 超出范围时返回错误
+func IntToBits(val int64, bits int) (uint64, error) {
+	if bits <= 0 || bits > 64 {
+		return 0, errors.Errorf("int bits should between 1 and 64, actual %d", bits)
+	}
+	if bits < 64 {
+		minVal, maxVal := -(int64(1) << (bits - 1)), int64(1)<<(bits-1)-1
+		if val < minVal || val > maxVal {
+			return 0, errors.Errorf("int value %d overflows %d bits, should between %d and %d", val, bits, minVal, maxVal)
+		}
+		return uint64(val) & (uint64(1)<<bits - 1), nil
+	}
+	return uint64(val), nil
+}
+
+// UintToBits 检查无符号整数是否能用 bits 位表示, 负数和超出范围时返回错误
+func UintToBits(val any, bits int) (uint64, error) {
+	if bits <= 0 || bits > 64 {
+		return 0, errors.Errorf("uint bits should between 1 and 64, actual %d", bits)
+	}
+	var u uint64
+	switch v := val.(type) {
+	case uint:
+		u = uint64(v)
+	case uint64:
+		u = v
+	default:
+		if i, ok := ToInt64(val); ok {
+			if i < 0 {
+				return 0, errors.Errorf("uint value %d should not be negative", i)
+			}
+			u = uint64(i)
+		} else {
+			parsed, err := strconv.ParseUint(ToString(val), 10, 64)
+			if err != nil {
+				return 0, errors.Errorf("value should be a uint, '%v'", val)
+			}
+			u = parsed
+		}
+	}
+	if bits < 64 && u >= uint64(1)<<bits {
+		return 0, errors.Errorf("uint value %d overflows %d bits, should not greater than %d", u, bits, uint64(1)<<bits-1)
+	}
+	return u, nil
+}
+
 func ToFloat64(val any) (float64, bool) {
 	switch v := val.(type) {
 	case int:
@@ -168,14 +273,6 @@ func YamlDecode[T any](node *yaml.Node) (*T, error) {
 	return &result, errors.WithStack(err)
 }
 
-func GetByteOrder(byteOrderKey string) (byteOrder binary.ByteOrder) {
-	byteOrder = binary.BigEndian
-	if byteOrderKey == "litter" {
-		byteOrder = binary.LittleEndian
-	}
-	return byteOrder
-}
-
 const (
 	PaddingLeft  string = "left"  // 在前面填充
 	PaddingRight string = "right" // 在后面填充
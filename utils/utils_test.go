@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bytes"
+	"math"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSignExtend(t *testing.T) {
+	cases := []struct {
+		val    uint64
+		bits   int
+		expect int64
+	}{
+		{0xFFFE, 16, -2},
+		{0x7FFF, 16, 32767},
+		{0xFFE, 12, -2},
+		{0x800, 12, -2048},
+		{0x80, 8, -128},
+		{0xFFFFFFFFFFFFFFFF, 64, -1},
+	}
+	for _, c := range cases {
+		if v := SignExtend(c.val, c.bits); v != c.expect {
+			t.Fatalf("SignExtend(%X, %d): expect %d, got %d", c.val, c.bits, c.expect, v)
+		}
+	}
+}
+
+func TestToInt64Round(t *testing.T) {
+	cases := []struct {
+		val    any
+		expect int64
+	}{
+		{2315.9, 2316},
+		{-2.5, -3},
+		{float32(23.4), 23},
+	}
+	for _, c := range cases {
+		if v, ok := ToInt64(c.val); !ok || v != c.expect {
+			t.Fatalf("ToInt64(%v): expect %d, got %d, %v", c.val, c.expect, v, ok)
+		}
+	}
+	for _, val := range []any{math.NaN(), math.Inf(1), 1e19} {
+		if _, ok := ToInt64(val); ok {
+			t.Fatalf("ToInt64(%v): expect not representable", val)
+		}
+	}
+	if v, err := UintToBits(2315.9, 16); err != nil || v != 2316 {
+		t.Fatalf("expect 2316, got %d, %v", v, err)
+	}
+}
+
+func TestIntToBits(t *testing.T) {
+	if v, err := IntToBits(-2, 12); err != nil || v != 0xFFE {
+		t.Fatalf("expect FFE, got %X, %v", v, err)
+	}
+	if _, err := IntToBits(128, 8); err == nil {
+		t.Fatal("expect overflow error for 128 in 8 bits")
+	}
+	if _, err := IntToBits(-129, 8); err == nil {
+		t.Fatal("expect overflow error for -129 in 8 bits")
+	}
+	if v, err := UintToBits(255, 8); err != nil || v != 255 {
+		t.Fatalf("expect 255, got %d, %v", v, err)
+	}
+	if _, err := UintToBits(256, 8); err == nil {
+		t.Fatal("expect overflow error for 256 in 8 bits")
+	}
+	if _, err := UintToBits(-1, 8); err == nil {
+		t.Fatal("expect error for negative uint")
+	}
+	if v, err := UintToBits(uint64(1<<63), 64); err != nil || v != 1<<63 {
+		t.Fatalf("expect %d, got %d, %v", uint64(1<<63), v, err)
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	cases := []struct {
+		bits uint16
+		val  float32
+	}{
+		{0x3c00, 1},
+		{0xc000, -2},
+		{0x3555, 0.333251953125},
+		{0x7bff, 65504},
+		{0x0001, 5.960464477539063e-08},
+		{0x7c00, float32(math.Inf(1))},
+	}
+	for _, c := range cases {
+		if v := Float16ToFloat32(c.bits); v != c.val {
+			t.Fatalf("Float16ToFloat32(%04X): expect %v, got %v", c.bits, c.val, v)
+		}
+		if h := Float32ToFloat16(c.val); h != c.bits {
+			t.Fatalf("Float32ToFloat16(%v): expect %04X, got %04X", c.val, c.bits, h)
+		}
+	}
+	if h := Float32ToFloat16(1e6); h != 0x7c00 {
+		t.Fatalf("expect overflow to Inf, got %04X", h)
+	}
+}
+
+func TestReorderBytes(t *testing.T) {
+	data := []byte{0xA, 0xB, 0xC, 0xD}
+	cases := map[string][]byte{
+		"abcd": {0xA, 0xB, 0xC, 0xD},
+		"dcba": {0xD, 0xC, 0xB, 0xA},
+		"cdab": {0xC, 0xD, 0xA, 0xB},
+		"badc": {0xB, 0xA, 0xD, 0xC},
+	}
+	for name, expect := range cases {
+		order := GetByteOrder(name)
+		if v := ReorderBytes(data, order); !bytes.Equal(v, expect) {
+			t.Fatalf("%s: expect %X, got %X", name, expect, v)
+		}
+		if v, _ := ConvertBytesToInt(expect, order); v != 0x0A0B0C0D {
+			t.Fatalf("%s: expect 0A0B0C0D, got %X", name, v)
+		}
+		if v := Uint64ToBytes(0x0A0B0C0D, 4, order); !bytes.Equal(v, expect) {
+			t.Fatalf("%s: expect %X, got %X", name, expect, v)
+		}
+	}
+}
+
+func TestBcd(t *testing.T) {
+	if s, err := DecodeBcd([]byte{0x01, 0x38, 0x00}); err != nil || s != "013800" {
+		t.Fatalf("expect 013800, got %s, %v", s, err)
+	}
+	if _, err := DecodeBcd([]byte{0x1a}); err == nil {
+		t.Fatal("expect error for invalid nibble")
+	}
+	if bs, err := EncodeBcd("1234", 3); err != nil || !bytes.Equal(bs, []byte{0x00, 0x12, 0x34}) {
+		t.Fatalf("expect 001234, got %X, %v", bs, err)
+	}
+	if _, err := EncodeBcd("1234567", 3); err == nil {
+		t.Fatal("expect overflow error")
+	}
+
+	if digits, negative, err := DecodePackedBcd([]byte{0x12, 0x3d}); err != nil || digits != "123" || !negative {
+		t.Fatalf("expect -123, got %s, %v, %v", digits, negative, err)
+	}
+	if _, _, err := DecodePackedBcd([]byte{0x12, 0x37}); err == nil {
+		t.Fatal("expect error for invalid sign nibble")
+	}
+	if bs, err := EncodePackedBcd("45", false, 2); err != nil || !bytes.Equal(bs, []byte{0x04, 0x5c}) {
+		t.Fatalf("expect 045C, got %X, %v", bs, err)
+	}
+}
+
+// endlessReader 每次读取都立即返回数据
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	return copy(p, "data"), nil
+}
+
+func TestIdleReaderClose(t *testing.T) {
+	base := runtime.NumGoroutine()
+	reader := NewIdleReader(endlessReader{}, time.Second)
+	buf := make([]byte, 4)
+	if _, err := reader.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	// 不再读取时, 后台协程阻塞在发送上, Close 后应该退出
+	_ = reader.Close()
+	_ = reader.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect idle reader goroutine to exit after Close, goroutines %d, base %d", runtime.NumGoroutine(), base)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
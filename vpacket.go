@@ -26,6 +26,7 @@ var NewContext = core.NewContext
 
 type Codec = core.Codec
 type ScanResult = core.ScanResult
+type CodecStats = core.CodecStats
 
 var NewCodec = core.NewCodec
 var NewCodecFromBytes = core.NewCodecFromBytes